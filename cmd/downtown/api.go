@@ -0,0 +1,379 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The JSON API lets scripts manage the tasks. Requests are authenticated
+// with the token returned by POST /api/v1/session or a personal API token
+// sent as a Bearer token, or with the session cookie of the web UI.
+
+// MaxAPIRequestSize limits the JSON bodies of the API requests.
+const MaxAPIRequestSize = 64 * KB
+
+type APIErrorBody struct {
+	Error APIErrorDetail `json:"error"`
+}
+
+// APIErrorDetail describes an error, API and Code are set when the error
+// comes from Download Station.
+type APIErrorDetail struct {
+	API     string `json:"api,omitempty"`
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message"`
+}
+
+type APILoginRequest struct {
+	User        string `json:"user"`
+	Pass        string `json:"pass"`
+	OTPCode     string `json:"otp_code"`
+	TrustDevice bool   `json:"trust_device"`
+	DeviceId    string `json:"device_id"`
+}
+
+type APILoginResponse struct {
+	Token     string    `json:"token"`
+	User      string    `json:"user"`
+	ExpiresAt time.Time `json:"expires_at"`
+	DeviceId  string    `json:"device_id,omitempty"`
+}
+
+type APINewTaskRequest struct {
+	Urls        []string `json:"urls"`
+	Destination string   `json:"destination"`
+}
+
+type APINewTaskResponse struct {
+	Results []CreateResult `json:"results"`
+}
+
+func (a *WebApp) apiRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("POST /api/v1/session", a.apiLogin)
+	mux.HandleFunc("DELETE /api/v1/session", a.apiAuthenticated(a.apiLogout))
+	mux.HandleFunc("GET /api/v1/tasks", a.apiAuthenticated(a.apiTasks))
+	mux.HandleFunc("POST /api/v1/tasks", a.apiAuthenticated(a.apiNewTask))
+	mux.HandleFunc("GET /api/v1/tasks/{id}", a.apiAuthenticated(a.apiTask))
+	mux.HandleFunc("DELETE /api/v1/tasks/{id}", a.apiAuthenticated(a.apiDeleteTask))
+	mux.HandleFunc("PUT /api/v1/tasks/{id}/pause", a.apiAuthenticated(a.apiPauseTask))
+	mux.HandleFunc("PUT /api/v1/tasks/{id}/resume", a.apiAuthenticated(a.apiResumeTask))
+	mux.HandleFunc("/api/", a.apiNotFound)
+}
+
+func writeJSON(w http.ResponseWriter, status int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(data)
+	if err != nil {
+		panic("error encoding response: " + err.Error())
+	}
+}
+
+func writeAPIErrorMessage(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, APIErrorBody{Error: APIErrorDetail{Message: message}})
+}
+
+// decodeAPIRequest decodes the JSON body of a request, no larger than
+// MaxAPIRequestSize. When it can't, the error is written with the message
+// of invalid requests and false is returned.
+func decodeAPIRequest(w http.ResponseWriter, r *http.Request, request any, invalid string) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, MaxAPIRequestSize)
+	err := json.NewDecoder(r.Body).Decode(request)
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxBytesErr):
+		writeAPIErrorMessage(w, http.StatusRequestEntityTooLarge, "Request body too large")
+	case err != nil:
+		writeAPIErrorMessage(w, http.StatusBadRequest, invalid)
+	}
+	return err == nil
+}
+
+// apiError writes the JSON error with the same status and message the web UI
+// would show. The session is forgotten when Download Station reports it
+// expired.
+func (a *WebApp) apiError(w http.ResponseWriter, r *http.Request, err error) {
+	var detail APIErrorDetail
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		detail.API, detail.Code = apiErr.API, apiErr.Code
+	}
+	status, message := errorResponse(err)
+	if errors.Is(err, ErrSessionExpired) {
+		a.forgetSession(r)
+		status = http.StatusUnauthorized
+	}
+	detail.Message = message
+	writeJSON(w, status, APIErrorBody{Error: detail})
+}
+
+// requestToken returns the Bearer token of the request, falling back to the
+// session cookie.
+func requestToken(r *http.Request) string {
+	if token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); found {
+		return strings.TrimSpace(token)
+	}
+	if cookie, err := r.Cookie(SessionCookieName); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+func (a *WebApp) apiAuthenticated(handlerFunc SessionHandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := requestToken(r)
+		if strings.HasPrefix(token, APITokenPrefix) {
+			session, err := a.tokenSession(r.Context(), token)
+			if errors.Is(err, ErrInvalidToken) {
+				writeAPIUnauthorized(w)
+				return
+			}
+			if err != nil {
+				a.Logger.Warn("api token login refused", "error", err)
+				a.apiError(w, r, err)
+				return
+			}
+			handlerFunc(w, r, session)
+			return
+		}
+		session, found := a.Sessions.Get(token)
+		if !found {
+			writeAPIUnauthorized(w)
+			return
+		}
+		handlerFunc(w, r, session)
+	}
+}
+
+func writeAPIUnauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="downtown"`)
+	writeAPIErrorMessage(w, http.StatusUnauthorized, "Authentication required")
+}
+
+// tokenSession returns the session of a personal API token, logging in to
+// DSM with the credentials of the token when there's none yet or it expired.
+// The requests of the same token wait for the login of the first one.
+func (a *WebApp) tokenSession(ctx context.Context, token string) (*Session, error) {
+	stored, credentials, ok := a.Tokens.Verify(token)
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	mu, _ := a.tokenLogins.LoadOrStore(stored.ID, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	defer mu.(*sync.Mutex).Unlock()
+	if sessionToken, found := a.tokenSessions.Load(stored.ID); found {
+		if session, found := a.Sessions.Get(sessionToken.(string)); found {
+			return session, nil
+		}
+	}
+	response, err := a.App.Client.Login(ctx, LoginRequest{
+		user:     credentials.User,
+		pass:     credentials.Pass,
+		deviceId: credentials.DeviceId,
+	})
+	if err != nil {
+		return nil, err
+	}
+	session, sessionToken := a.Sessions.Create(credentials.User, response.Data.SID)
+	a.tokenSessions.Store(stored.ID, sessionToken)
+	return session, nil
+}
+
+// forgetSession deletes the session of the request, the one opened for its
+// personal API token if it has one.
+func (a *WebApp) forgetSession(r *http.Request) {
+	token := requestToken(r)
+	if id, _, ok := parseAPIToken(token); ok {
+		a.forgetTokenSession(id)
+		return
+	}
+	a.Sessions.Delete(token)
+}
+
+func (a *WebApp) forgetTokenSession(id string) *Session {
+	sessionToken, found := a.tokenSessions.LoadAndDelete(id)
+	if !found {
+		return nil
+	}
+	session, _ := a.Sessions.Get(sessionToken.(string))
+	a.Sessions.Delete(sessionToken.(string))
+	return session
+}
+
+func (a *WebApp) apiLogin(w http.ResponseWriter, r *http.Request) {
+	var request APILoginRequest
+	if !decodeAPIRequest(w, r, &request, "Invalid login request") {
+		return
+	}
+	response, err := a.App.Client.Login(r.Context(), LoginRequest{
+		user:        request.User,
+		pass:        request.Pass,
+		otpCode:     request.OTPCode,
+		trustDevice: request.TrustDevice,
+		deviceId:    request.DeviceId,
+	})
+	if errors.Is(err, ErrOTPRequired) || errors.Is(err, ErrOTPInvalid) {
+		var apiErr *APIError
+		errors.As(err, &apiErr)
+		writeJSON(w, http.StatusUnauthorized, APIErrorBody{Error: APIErrorDetail{API: apiErr.API, Code: apiErr.Code, Message: apiErr.Message()}})
+		return
+	}
+	if err != nil {
+		a.Logger.Warn("api login refused", "user", request.User, "error", err)
+		a.apiError(w, r, err)
+		return
+	}
+	session, token := a.Sessions.Create(request.User, response.Data.SID)
+	writeJSON(w, http.StatusCreated, APILoginResponse{
+		Token:     token,
+		User:      session.User,
+		ExpiresAt: session.CreatedAt.Add(a.App.Config.sessionMaxAge),
+		DeviceId:  response.Data.DID,
+	})
+}
+
+func (a *WebApp) apiLogout(w http.ResponseWriter, r *http.Request, session *Session) {
+	if err := a.App.Client.Logout(r.Context(), session.SID); err != nil {
+		a.Logger.Warn("download station logout error", "error", err)
+	}
+	a.forgetSession(r)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *WebApp) apiTasks(w http.ResponseWriter, r *http.Request, session *Session) {
+	offset, validOffset := queryCount(r, "offset")
+	limit, validLimit := queryCount(r, "limit")
+	if !validOffset || !validLimit {
+		writeAPIErrorMessage(w, http.StatusBadRequest, "Invalid offset or limit")
+		return
+	}
+	tasks, err := a.TasksCache.GetPage(r.Context(), session.User, session.SID, TasksPage{Offset: offset, Limit: limit})
+	if err != nil {
+		a.Logger.Error("api tasks error", "error", err)
+		a.apiError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tasks)
+}
+
+// queryCount reads a non-negative number from the query, zero when missing.
+func queryCount(r *http.Request, name string) (int, bool) {
+	if !r.URL.Query().Has(name) {
+		return 0, true
+	}
+	n, err := strconv.Atoi(r.URL.Query().Get(name))
+	return n, err == nil && n >= 0
+}
+
+func (a *WebApp) apiTask(w http.ResponseWriter, r *http.Request, session *Session) {
+	task, err := a.App.Client.GetTask(r.Context(), session.SID, r.PathValue("id"))
+	if err != nil {
+		a.Logger.Error("api task error", "error", err)
+		a.apiError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, task)
+}
+
+// apiNewTask creates tasks from a JSON list of links or from a multipart form
+// like the one of the web UI. The response is 201 when all the tasks are
+// created, 207 when only some of them and 422 when none, with the outcome of
+// each link and file.
+func (a *WebApp) apiNewTask(w http.ResponseWriter, r *http.Request, session *Session) {
+	var input newTasks
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/json":
+		var request APINewTaskRequest
+		if !decodeAPIRequest(w, r, &request, "Invalid task request") {
+			return
+		}
+		input.links, input.invalid = ParseLinks(strings.Join(request.Urls, "\n"))
+		input.destination = request.Destination
+	case "multipart/form-data":
+		r.Body = http.MaxBytesReader(w, r.Body, MaxUploadSize+64*KB)
+		err := r.ParseMultipartForm(MaxUploadSize)
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeAPIErrorMessage(w, http.StatusRequestEntityTooLarge, "Files can't be larger than "+HumanizeSize(MaxUploadSize))
+			return
+		}
+		if err != nil {
+			writeAPIErrorMessage(w, http.StatusBadRequest, "Invalid upload")
+			return
+		}
+		input.links, input.invalid = ParseLinks(r.FormValue("urls"))
+		input.destination = r.FormValue("destination")
+		file, header, err := r.FormFile("file")
+		if err == nil {
+			defer func() {
+				_ = file.Close()
+			}()
+			if header.Size > MaxUploadSize {
+				writeAPIErrorMessage(w, http.StatusRequestEntityTooLarge, "Files can't be larger than "+HumanizeSize(MaxUploadSize))
+				return
+			}
+			input.file, input.header = file, header
+		}
+	default:
+		writeAPIErrorMessage(w, http.StatusUnsupportedMediaType, "Send the links as JSON or a multipart form")
+		return
+	}
+
+	results, err := a.createTasks(r.Context(), session, input)
+	if err != nil {
+		a.apiError(w, r, err)
+		return
+	}
+	if len(results) == 0 {
+		writeAPIErrorMessage(w, http.StatusBadRequest, "No links or file to create tasks from")
+		return
+	}
+	failed := 0
+	for _, result := range results {
+		if result.Error != "" {
+			failed++
+		}
+	}
+	status := http.StatusCreated
+	if failed == len(results) {
+		status = http.StatusUnprocessableEntity
+	} else if failed > 0 {
+		status = http.StatusMultiStatus
+	}
+	writeJSON(w, status, APINewTaskResponse{Results: results})
+}
+
+func (a *WebApp) apiDeleteTask(w http.ResponseWriter, r *http.Request, session *Session) {
+	a.apiChangeTask(w, r, session, "delete", a.App.Client.DeleteTask(r.Context(), session.SID, r.PathValue("id"), deleteOptions(r)))
+}
+
+func (a *WebApp) apiPauseTask(w http.ResponseWriter, r *http.Request, session *Session) {
+	a.apiChangeTask(w, r, session, "pause", a.App.Client.PauseTask(r.Context(), session.SID, r.PathValue("id")))
+}
+
+func (a *WebApp) apiResumeTask(w http.ResponseWriter, r *http.Request, session *Session) {
+	a.apiChangeTask(w, r, session, "resume", a.App.Client.ResumeTask(r.Context(), session.SID, r.PathValue("id")))
+}
+
+func (a *WebApp) apiChangeTask(w http.ResponseWriter, r *http.Request, session *Session, method string, err error) {
+	a.tasksChanged(session)
+	if err != nil {
+		a.Logger.Error("api "+method+" task error", "error", err)
+		a.apiError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *WebApp) apiNotFound(w http.ResponseWriter, r *http.Request) {
+	writeAPIErrorMessage(w, http.StatusNotFound, "No API endpoint "+r.Method+" "+r.URL.Path)
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAPILogin(t *testing.T) {
+	a, s := testWebApp(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"data":{"sid":"SID"},"success":true}`))
+	})
+	defer s.Close()
+
+	req := httptest.NewRequest("POST", "/api/v1/session", strings.NewReader(`{"user":"user","pass":"pass"}`))
+	rec := httptest.NewRecorder()
+	a.routes().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status %d expected %d", rec.Code, http.StatusCreated)
+	}
+	var response APILoginResponse
+	if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	session, found := a.Sessions.Get(response.Token)
+	if !found || session.SID != "SID" || session.User != "user" {
+		t.Errorf("session for the token expected, got %+v", session)
+	}
+}
+
+func TestAPILoginTooLarge(t *testing.T) {
+	a, s := testWebApp(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("no login expected with a body too large")
+	})
+	defer s.Close()
+
+	body := `{"user":"user","pass":"` + strings.Repeat("a", MaxAPIRequestSize) + `"}`
+	req := httptest.NewRequest("POST", "/api/v1/session", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	a.routes().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status %d expected %d", rec.Code, http.StatusRequestEntityTooLarge)
+	}
+}
+
+func TestAPITasksBearer(t *testing.T) {
+	a, s := testWebApp(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"data":{"offset":0,"tasks":[{"id":"ID1","status":"downloading","title":"a.iso"}],"total":1},"success":true}`))
+	})
+	defer s.Close()
+	_, token := a.Sessions.Create("user", "SID")
+
+	req := httptest.NewRequest("GET", "/api/v1/tasks", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	a.routes().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d expected %d", rec.Code, http.StatusOK)
+	}
+	var tasks TasksData
+	if err := json.NewDecoder(rec.Body).Decode(&tasks); err != nil {
+		t.Fatal(err)
+	}
+	if tasks.Total != 1 || tasks.Tasks[0].Id != "ID1" {
+		t.Errorf("tasks %+v not returned as expected", tasks)
+	}
+}
+
+func TestAPIUnauthenticated(t *testing.T) {
+	a, s := testWebApp(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("no request to Download Station expected")
+	})
+	defer s.Close()
+
+	req := httptest.NewRequest("GET", "/api/v1/tasks", nil)
+	req.Header.Set("Authorization", "Bearer forged.token")
+	rec := httptest.NewRecorder()
+	a.routes().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusUnauthorized || rec.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("status %d with a Bearer challenge expected, got %d", http.StatusUnauthorized, rec.Code)
+	}
+	if contentType := rec.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("JSON error expected, got %s", contentType)
+	}
+}
+
+func TestAPIErrors(t *testing.T) {
+	testCases := []struct {
+		name     string
+		response string
+		status   int
+		code     int
+		message  string
+	}{
+		{"task error", `{"data":[{"id":"ID1","error":405}],"success":true}`, http.StatusConflict, 405, "Invalid task action"},
+		{"request error", `{"success":false,"error":{"code":101}}`, http.StatusBadRequest, 101, "Invalid parameter"},
+		{"session expired", `{"success":false,"error":{"code":106}}`, http.StatusUnauthorized, 106, "Session timeout"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			a, s := testWebApp(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+				_, _ = w.Write([]byte(tc.response))
+			})
+			defer s.Close()
+
+			req := httptest.NewRequest("PUT", "/api/v1/tasks/ID1/pause", nil)
+			req.AddCookie(testSessionCookie(a))
+			rec := httptest.NewRecorder()
+			a.routes().ServeHTTP(rec, req)
+
+			if rec.Code != tc.status {
+				t.Errorf("status %d expected %d", rec.Code, tc.status)
+			}
+			var body APIErrorBody
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Error.Code != tc.code || body.Error.Message != tc.message || body.Error.API != TaskAPI {
+				t.Errorf("error %+v while code %d '%s' expected", body.Error, tc.code, tc.message)
+			}
+		})
+	}
+}
+
+func TestAPINewTask(t *testing.T) {
+	a, s := testWebApp(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("uri") != "magnet:?xt=urn:btih:ABC" || r.FormValue("destination") != "video" {
+			t.Errorf("uri '%s' destination '%s' sent", r.FormValue("uri"), r.FormValue("destination"))
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"success":true}`))
+	})
+	defer s.Close()
+
+	req := httptest.NewRequest("POST", "/api/v1/tasks", strings.NewReader(`{"urls":["magnet:?xt=urn:btih:ABC","not a link"],"destination":"/video"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.AddCookie(testSessionCookie(a))
+	rec := httptest.NewRecorder()
+	a.routes().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusMultiStatus {
+		t.Errorf("status %d expected %d", rec.Code, http.StatusMultiStatus)
+	}
+	var response APINewTaskResponse
+	if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	expected := []CreateResult{{Source: "not a link", Error: "Not a supported link"}, {Source: "magnet:?xt=urn:btih:ABC"}}
+	if len(response.Results) != 2 || response.Results[0] != expected[0] || response.Results[1] != expected[1] {
+		t.Errorf("results %+v while %+v expected", response.Results, expected)
+	}
+}
+
+func TestAPIPersonalToken(t *testing.T) {
+	var logins atomic.Int32
+	a, s := testWebApp(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		if r.FormValue("method") == "login" {
+			logins.Add(1)
+			time.Sleep(10 * time.Millisecond)
+			if r.FormValue("account") != "user" || r.FormValue("passwd") != "pass" || r.FormValue("device_id") != "DID" {
+				t.Errorf("login with the token credentials expected, got %v", r.Form)
+			}
+			_, _ = w.Write([]byte(`{"data":{"sid":"TOKENSID"},"success":true}`))
+			return
+		}
+		if r.FormValue("_sid") != "TOKENSID" {
+			t.Errorf("sid of the token session expected, got '%s'", r.FormValue("_sid"))
+		}
+		_, _ = w.Write([]byte(`{"data":{"offset":0,"tasks":[],"total":0},"success":true}`))
+	})
+	defer s.Close()
+	a.Tokens, _ = testTokenStore(t)
+	token, err := a.Tokens.Create("script", NASCredentials{User: "user", Pass: "pass", DeviceId: "DID"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for range 3 {
+		wg.Go(func() {
+			req := httptest.NewRequest("GET", "/api/v1/tasks", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			rec := httptest.NewRecorder()
+			a.routes().ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Errorf("status %d expected %d", rec.Code, http.StatusOK)
+			}
+		})
+	}
+	wg.Wait()
+	if logins.Load() != 1 {
+		t.Errorf("one login expected for the token, got %d", logins.Load())
+	}
+
+	req := httptest.NewRequest("GET", "/api/v1/tasks", nil)
+	req.Header.Set("Authorization", "Bearer "+token+"x")
+	rec := httptest.NewRecorder()
+	a.routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("status %d expected for a forged token, got %d", http.StatusUnauthorized, rec.Code)
+	}
+}
+
+func TestRevokeTokenForgetsLogin(t *testing.T) {
+	a, s := testWebApp(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		if r.FormValue("method") == "login" {
+			_, _ = w.Write([]byte(`{"data":{"sid":"TOKENSID"},"success":true}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":{"offset":0,"tasks":[],"total":0},"success":true}`))
+	})
+	defer s.Close()
+	a.Tokens, _ = testTokenStore(t)
+	token, err := a.Tokens.Create("script", NASCredentials{User: "user", Pass: "pass"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("GET", "/api/v1/tasks", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	a.routes().ServeHTTP(httptest.NewRecorder(), req)
+	id := a.Tokens.List("user")[0].ID
+	if _, found := a.tokenLogins.Load(id); !found {
+		t.Fatal("login mutex of the token expected after its first use")
+	}
+
+	req = httptest.NewRequest("DELETE", "/settings/tokens/"+id, nil)
+	req.AddCookie(testSessionCookie(a))
+	a.routes().ServeHTTP(httptest.NewRecorder(), req)
+	if _, found := a.tokenLogins.Load(id); found {
+		t.Error("login mutex of the revoked token not removed")
+	}
+	if _, found := a.tokenSessions.Load(id); found {
+		t.Error("session of the revoked token not removed")
+	}
+}
+
+func TestAPITasksPage(t *testing.T) {
+	a, s := testWebApp(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("offset") != "100" || r.FormValue("limit") != "20" {
+			t.Errorf("offset '%s' and limit '%s' sent while 100 and 20 expected", r.FormValue("offset"), r.FormValue("limit"))
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"data":{"offset":100,"tasks":[],"total":120},"success":true}`))
+	})
+	defer s.Close()
+	_, token := a.Sessions.Create("user", "SID")
+
+	for query, status := range map[string]int{"offset=100&limit=20": http.StatusOK, "offset=-1": http.StatusBadRequest} {
+		req := httptest.NewRequest("GET", "/api/v1/tasks?"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		a.routes().ServeHTTP(rec, req)
+		if rec.Code != status {
+			t.Errorf("status %d expected for '%s', got %d", status, query, rec.Code)
+		}
+	}
+}
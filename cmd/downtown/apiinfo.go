@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	InfoAPI  = "SYNO.API.Info"
+	InfoPath = "query.cgi"
+)
+
+// ErrAPIUnavailable is returned by the discovery when the NAS doesn't expose
+// an API required by Downtown in a version the client can speak.
+var ErrAPIUnavailable = errors.New("required API not available")
+
+type APIInfo struct {
+	Path       string `json:"path"`
+	MinVersion int    `json:"minVersion"`
+	MaxVersion int    `json:"maxVersion"`
+}
+
+// Endpoint is where and in which version an API is called.
+type Endpoint struct {
+	Path    string
+	Version int
+}
+
+type versionRange struct {
+	min int
+	max int
+}
+
+// supportedAPIs are the API versions the client knows how to speak.
+var supportedAPIs = map[string]versionRange{
+	AuthAPI:       {3, 6},
+	TaskAPI:       {1, 3},
+	Task2API:      {2, 2},
+	BTFileAPI:     {2, 2},
+	LocationAPI:   {1, 1},
+	FileListAPI:   {2, 2},
+	FileDeleteAPI: {2, 2},
+}
+
+// requiredAPIs must be available for Downtown to work, at least one API of
+// each group.
+var requiredAPIs = [][]string{
+	{AuthAPI},
+	{Task2API, TaskAPI},
+}
+
+// DiscoveryTimeout bounds a single SYNO.API.Info call.
+const DiscoveryTimeout = 30 * time.Second
+
+// discoveryCall is a discovery shared by the requests waiting for it.
+type discoveryCall struct {
+	done chan struct{}
+	err  error
+}
+
+// Discover queries SYNO.API.Info and caches the path and the highest common
+// version of every supported API. It's called on first use, calling it at
+// startup makes a NAS missing a required API fail early.
+func (c *Client) Discover(ctx context.Context) error {
+	c.discoveryMu.Lock()
+	call := c.startDiscovery()
+	c.discoveryMu.Unlock()
+	return waitDiscovery(ctx, call)
+}
+
+// startDiscovery returns the discovery in flight, starting one if none is.
+// The discovery runs detached from the request that started it with its own
+// timeout, so the lock is never held during the call. discoveryMu must be
+// held.
+func (c *Client) startDiscovery() *discoveryCall {
+	if c.discovery != nil {
+		return c.discovery
+	}
+	call := &discoveryCall{done: make(chan struct{})}
+	c.discovery = call
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), DiscoveryTimeout)
+		defer cancel()
+		endpoints, err := c.discover(ctx)
+
+		c.discoveryMu.Lock()
+		defer c.discoveryMu.Unlock()
+		if err == nil {
+			c.endpoints = endpoints
+		}
+		call.err = err
+		c.discovery = nil
+		close(call.done)
+	}()
+	return call
+}
+
+func waitDiscovery(ctx context.Context, call *discoveryCall) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-call.done:
+		return call.err
+	}
+}
+
+func (c *Client) discover(ctx context.Context) (map[string]Endpoint, error) {
+	apis := make([]string, 0, len(supportedAPIs))
+	for api := range supportedAPIs {
+		apis = append(apis, api)
+	}
+	slices.Sort(apis)
+	params := url.Values{
+		"api":     {InfoAPI},
+		"version": {"1"},
+		"method":  {"query"},
+		"query":   {strings.Join(apis, ",")},
+	}
+	request, err := c.createRequest(ctx, InfoPath, params)
+	if err != nil {
+		return nil, fmt.Errorf("creating api info request: %w", err)
+	}
+	var response Response[map[string]APIInfo]
+	err = doRequest(c, InfoAPI, "api info", request, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoints := make(map[string]Endpoint)
+	for _, api := range apis {
+		info, found := response.Data[api]
+		if !found {
+			continue
+		}
+		supported := supportedAPIs[api]
+		version := min(info.MaxVersion, supported.max)
+		if version < max(info.MinVersion, supported.min) {
+			c.logger.Warn("unsupported API version", "api", api, "minVersion", info.MinVersion, "maxVersion", info.MaxVersion)
+			continue
+		}
+		endpoints[api] = Endpoint{Path: info.Path, Version: version}
+	}
+	for _, group := range requiredAPIs {
+		available := slices.ContainsFunc(group, func(api string) bool {
+			_, found := endpoints[api]
+			return found
+		})
+		if !available {
+			return nil, fmt.Errorf("%w: %s", ErrAPIUnavailable, strings.Join(group, " or "))
+		}
+	}
+	c.logger.Debug("APIs discovered", "endpoints", endpoints)
+	return endpoints, nil
+}
+
+// endpoint returns the cached endpoint of an API, running the discovery if
+// it didn't complete yet.
+func (c *Client) endpoint(ctx context.Context, api string) (Endpoint, error) {
+	c.discoveryMu.Lock()
+	endpoints := c.endpoints
+	var call *discoveryCall
+	if endpoints == nil {
+		call = c.startDiscovery()
+	}
+	c.discoveryMu.Unlock()
+	if call != nil {
+		if err := waitDiscovery(ctx, call); err != nil {
+			return Endpoint{}, fmt.Errorf("discovering APIs: %w", err)
+		}
+		c.discoveryMu.Lock()
+		endpoints = c.endpoints
+		c.discoveryMu.Unlock()
+	}
+	endpoint, found := endpoints[api]
+	if !found {
+		return Endpoint{}, fmt.Errorf("%w: %s", ErrAPIUnavailable, api)
+	}
+	return endpoint, nil
+}
+
+func (c *Client) endpointParams(ctx context.Context, api string, method string, params url.Values) (Endpoint, url.Values, error) {
+	endpoint, err := c.endpoint(ctx, api)
+	if err != nil {
+		return Endpoint{}, nil, err
+	}
+	if params == nil {
+		params = url.Values{}
+	}
+	params.Set("api", api)
+	params.Set("version", strconv.Itoa(endpoint.Version))
+	params.Set("method", method)
+	return endpoint, params, nil
+}
+
+// newRequest creates a GET request for a method of a discovered API. The
+// request is authenticated when sid isn't empty.
+func (c *Client) newRequest(ctx context.Context, api string, method string, sid string, params url.Values) (*http.Request, error) {
+	endpoint, params, err := c.endpointParams(ctx, api, method, params)
+	if err != nil {
+		return nil, err
+	}
+	if sid != "" {
+		return c.createAuthenticatedRequest(ctx, endpoint.Path, sid, params)
+	}
+	return c.createRequest(ctx, endpoint.Path, params)
+}
+
+// newPostRequest creates a form POST request for a method of a discovered
+// API.
+func (c *Client) newPostRequest(ctx context.Context, api string, method string, params url.Values) (*http.Request, error) {
+	endpoint, params, err := c.endpointParams(ctx, api, method, params)
+	if err != nil {
+		return nil, err
+	}
+	return c.createPostRequest(ctx, endpoint.Path, params)
+}
+
+// newMultipartRequest creates a multipart POST request uploading a file
+// along with the parameters of a method of a discovered API.
+func (c *Client) newMultipartRequest(ctx context.Context, api string, method string, sid string, params url.Values, upload FileUpload) (*http.Request, error) {
+	endpoint, params, err := c.endpointParams(ctx, api, method, params)
+	if err != nil {
+		return nil, err
+	}
+	params.Set("_sid", sid)
+	return c.createMultipartRequest(ctx, endpoint.Path, params, upload)
+}
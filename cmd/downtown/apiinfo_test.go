@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const ExpectedInfoUrl = "/webapi/query.cgi?api=SYNO.API.Info&method=query&query=SYNO.API.Auth%2CSYNO.DownloadStation.Task%2CSYNO.DownloadStation2.Settings.Location%2CSYNO.DownloadStation2.Task%2CSYNO.DownloadStation2.Task.BT.File%2CSYNO.FileStation.Delete%2CSYNO.FileStation.List&version=1"
+
+func TestDiscover(t *testing.T) {
+	infoRequests := 0
+	c, s := testInfoClient(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/webapi/query.cgi":
+			infoRequests++
+			if r.URL.RequestURI() != ExpectedInfoUrl {
+				t.Errorf("api info url '%s' used but expected '%s'", r.URL.RequestURI(), ExpectedInfoUrl)
+			}
+			_, _ = w.Write([]byte(`{"data":{
+				"SYNO.API.Auth":{"maxVersion":7,"minVersion":1,"path":"entry.cgi"},
+				"SYNO.DownloadStation.Task":{"maxVersion":2,"minVersion":1,"path":"DownloadStation/task2.cgi"}
+			},"success":true}`))
+		case "/webapi/DownloadStation/task2.cgi":
+			if r.URL.Query().Get("version") != "2" {
+				t.Errorf("task api version %s used but expected 2", r.URL.Query().Get("version"))
+			}
+			_, _ = w.Write([]byte(`{"data":[{"id":"ID1","error":0}],"success":true}`))
+		default:
+			t.Errorf("unexpected request to %s", r.URL.Path)
+		}
+	})
+	defer s.Close()
+
+	for range 2 {
+		if err := c.PauseTask(context.Background(), "SID", "ID1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if infoRequests != 1 {
+		t.Errorf("api info requested %d times while once expected", infoRequests)
+	}
+
+	endpoint, err := c.endpoint(context.Background(), AuthAPI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if endpoint.Path != "entry.cgi" || endpoint.Version != 6 {
+		t.Errorf("auth endpoint %+v while entry.cgi version 6 expected", endpoint)
+	}
+}
+
+func TestDiscoverMissingAPI(t *testing.T) {
+	testCases := []string{
+		`{"data":{"SYNO.API.Auth":{"maxVersion":7,"minVersion":1,"path":"entry.cgi"}},"success":true}`,
+		`{"data":{
+			"SYNO.API.Auth":{"maxVersion":7,"minVersion":1,"path":"entry.cgi"},
+			"SYNO.DownloadStation.Task":{"maxVersion":9,"minVersion":8,"path":"DownloadStation/task.cgi"}
+		},"success":true}`,
+	}
+
+	for _, apiInfo := range testCases {
+		c, s := testInfoClient(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(apiInfo))
+		})
+		err := c.Discover(context.Background())
+		s.Close()
+		if !errors.Is(err, ErrAPIUnavailable) {
+			t.Errorf("error '%v' expected to be ErrAPIUnavailable", err)
+		}
+		if err != nil && !strings.Contains(err.Error(), TaskAPI) {
+			t.Errorf("error '%v' expected to name the missing API", err)
+		}
+	}
+}
+
+func TestDiscoverShared(t *testing.T) {
+	var infoRequests atomic.Int32
+	release := make(chan struct{})
+	c, s := testInfoClient(func(w http.ResponseWriter, r *http.Request) {
+		infoRequests.Add(1)
+		<-release
+		_, _ = w.Write([]byte(`{"data":{
+			"SYNO.API.Auth":{"maxVersion":7,"minVersion":1,"path":"entry.cgi"},
+			"SYNO.DownloadStation.Task":{"maxVersion":2,"minVersion":1,"path":"DownloadStation/task.cgi"}
+		},"success":true}`))
+	})
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := c.endpoint(ctx, AuthAPI); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("deadline exceeded expected while the discovery is slow, got %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for range 3 {
+		wg.Go(func() {
+			if _, err := c.endpoint(context.Background(), TaskAPI); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if infoRequests.Load() != 1 {
+		t.Errorf("api info requested %d times while once expected", infoRequests.Load())
+	}
+}
+
+func testInfoClient(f http.HandlerFunc) (*Client, *httptest.Server) {
+	ts := httptest.NewTLSServer(f)
+	host := strings.TrimPrefix(ts.URL, "https://")
+	c, err := NewClient(ClientConfig{Host: host}, slog.Default())
+	if err != nil {
+		panic(err)
+	}
+	return c, ts
+}
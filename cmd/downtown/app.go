@@ -4,12 +4,25 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"time"
 )
 
 type AppConfig struct {
-	host    string
-	addr    string
-	devMode string
+	host               string
+	scheme             string
+	tlsVerify          bool
+	caFile             string
+	certFingerprint    string
+	serverName         string
+	addr               string
+	devMode            string
+	dataDir            string
+	tasksPollInterval  time.Duration
+	tasksCacheTTL      time.Duration
+	sessionSecret      string
+	sessionIdleTimeout time.Duration
+	sessionMaxAge      time.Duration
+	cookieSecure       string
 }
 
 type App struct {
@@ -23,6 +36,32 @@ func LoadAppConfig() *AppConfig {
 		host:    requireEnvVar("DOWNLOAD_STATION_HOST"),
 		addr:    optionalEnvVar("ADDR", ":4000"),
 		devMode: optionalEnvVar("DEV_MODE", "false"),
+		dataDir: optionalEnvVar("DATA_DIR", "data"),
+
+		tasksPollInterval: durationEnvVar("TASKS_POLL_INTERVAL", 5*time.Second),
+		tasksCacheTTL:     durationEnvVar("TASKS_CACHE_TTL", 3*time.Second),
+
+		scheme:          optionalEnvVar("DOWNLOAD_STATION_SCHEME", "https"),
+		tlsVerify:       optionalEnvVar("DOWNLOAD_STATION_TLS_VERIFY", "false") == "true",
+		caFile:          optionalEnvVar("DOWNLOAD_STATION_CA_FILE", ""),
+		certFingerprint: optionalEnvVar("DOWNLOAD_STATION_CERT_FINGERPRINT", ""),
+		serverName:      optionalEnvVar("DOWNLOAD_STATION_SERVER_NAME", ""),
+
+		sessionSecret:      optionalEnvVar("SESSION_SECRET", ""),
+		sessionIdleTimeout: durationEnvVar("SESSION_IDLE_TIMEOUT", 2*time.Hour),
+		sessionMaxAge:      durationEnvVar("SESSION_MAX_AGE", 24*time.Hour),
+		cookieSecure:       optionalEnvVar("COOKIE_SECURE", "auto"),
+	}
+}
+
+func (c *AppConfig) ClientConfig() ClientConfig {
+	return ClientConfig{
+		Host:            c.host,
+		Scheme:          c.scheme,
+		TLSVerify:       c.tlsVerify,
+		CAFile:          c.caFile,
+		CertFingerprint: c.certFingerprint,
+		ServerName:      c.serverName,
 	}
 }
 
@@ -41,3 +80,15 @@ func optionalEnvVar(name string, defaultValue string) string {
 	}
 	return value
 }
+
+func durationEnvVar(name string, defaultValue time.Duration) time.Duration {
+	value, found := os.LookupEnv(name)
+	if !found {
+		return defaultValue
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		panic(fmt.Sprintf("environment variable %s is not a valid duration: %s", name, value))
+	}
+	return duration
+}
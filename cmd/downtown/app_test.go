@@ -2,6 +2,7 @@ package main
 
 import (
 	"testing"
+	"time"
 )
 
 func TestLoadAppConfig(t *testing.T) {
@@ -15,6 +16,14 @@ func TestLoadAppConfig(t *testing.T) {
 		t.Errorf("config.addr = %s; want default value :4000", config.addr)
 	}
 
+	if config.sessionIdleTimeout != 2*time.Hour || config.sessionMaxAge != 24*time.Hour {
+		t.Errorf("session timeouts = %s, %s; want default values 2h, 24h", config.sessionIdleTimeout, config.sessionMaxAge)
+	}
+
+	if config.scheme != "https" || config.tlsVerify {
+		t.Errorf("config.scheme = %s, config.tlsVerify = %t; want default values https, false", config.scheme, config.tlsVerify)
+	}
+
 	t.Setenv("ADDR", "localhost:8000")
 	config = LoadAppConfig()
 	if config.addr != "localhost:8000" {
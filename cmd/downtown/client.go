@@ -1,38 +1,121 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"maps"
+	"mime/multipart"
 	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 const (
-	LoginUrl      = "https://%s/webapi/entry.cgi?api=SYNO.API.Auth&version=6&method=login&account=%s&passwd=%s&session=DownloadStation&format=sid"
-	TasksUrl      = "https://%s/webapi/DownloadStation/task.cgi?api=SYNO.DownloadStation.Task&version=1&method=list&additional=transfer"
-	CreateTaskUrl = "https://%s/webapi/DownloadStation/task.cgi?api=SYNO.DownloadStation.Task&version=1&method=create&uri=%s"
-	DeleteTaskUrl = "https://%s/webapi/DownloadStation/task.cgi?api=SYNO.DownloadStation.Task&version=1&method=delete&id=%s"
-	PauseTaskUrl  = "https://%s/webapi/DownloadStation/task.cgi?api=SYNO.DownloadStation.Task&version=1&method=pause&id=%s"
-	ResumeTaskUrl = "https://%s/webapi/DownloadStation/task.cgi?api=SYNO.DownloadStation.Task&version=1&method=resume&id=%s"
+	AuthAPI = "SYNO.API.Auth"
+	TaskAPI = "SYNO.DownloadStation.Task"
 )
 
+// sensitiveParams are the request parameters hidden from the logs.
+var sensitiveParams = []string{"passwd", "_sid", "otp_code", "device_id"}
+
+// DeviceName identifies Downtown in the DSM list of trusted devices.
+const DeviceName = "Downtown"
+
+// ClientConfig describes how to connect to the NAS. Certificate
+// verification is disabled unless TLSVerify or CAFile are set, since DSM
+// ships with a self-signed certificate. CertFingerprint pins the SHA-256
+// fingerprint of the NAS certificate instead of verifying its chain.
+type ClientConfig struct {
+	Host            string
+	Scheme          string
+	TLSVerify       bool
+	CAFile          string
+	CertFingerprint string
+	ServerName      string
+}
+
 type Client struct {
-	client http.Client
-	host   string
-	logger *slog.Logger
+	client  http.Client
+	baseUrl string
+	logger  *slog.Logger
+
+	discoveryMu sync.Mutex
+	endpoints   map[string]Endpoint
+	discovery   *discoveryCall
 }
 
-func NewClient(host string, logger *slog.Logger) *Client {
+func NewClient(config ClientConfig, logger *slog.Logger) (*Client, error) {
+	scheme := config.Scheme
+	if scheme == "" {
+		scheme = "https"
+	}
+	if scheme != "https" && scheme != "http" {
+		return nil, fmt.Errorf("unsupported scheme %s", scheme)
+	}
+	tlsConfig, err := config.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
 	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		TLSClientConfig: tlsConfig,
 	}
 	return &Client{
-		client: http.Client{Transport: tr},
-		host:   host,
-		logger: logger,
+		client:  http.Client{Transport: tr},
+		baseUrl: scheme + "://" + config.Host,
+		logger:  logger,
+	}, nil
+}
+
+func (config ClientConfig) tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{ServerName: config.ServerName}
+	if config.CAFile != "" {
+		pem, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", config.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	switch {
+	case config.CertFingerprint != "":
+		fingerprint, err := hex.DecodeString(strings.ReplaceAll(config.CertFingerprint, ":", ""))
+		if err != nil || len(fingerprint) != sha256.Size {
+			return nil, fmt.Errorf("invalid SHA-256 certificate fingerprint %s", config.CertFingerprint)
+		}
+		// the chain isn't verified, the pinned certificate is trusted as is
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+			if len(state.PeerCertificates) == 0 {
+				return errors.New("no certificate presented by the NAS")
+			}
+			sum := sha256.Sum256(state.PeerCertificates[0].Raw)
+			if !bytes.Equal(sum[:], fingerprint) {
+				return fmt.Errorf("NAS certificate fingerprint %x doesn't match the pinned one", sum)
+			}
+			return nil
+		}
+	case !config.TLSVerify && config.CAFile == "":
+		tlsConfig.InsecureSkipVerify = true
 	}
+	return tlsConfig, nil
 }
 
 type Response[T any] struct {
@@ -43,8 +126,8 @@ type Response[T any] struct {
 	Data T `json:"data"`
 }
 
-func doRequest[T any](c *Client, name string, request *http.Request, response *Response[T]) error {
-	c.logger.Debug("executing DS request", "name", name, "method", request.Method, "url", request.URL)
+func doRequest[T any](c *Client, api string, name string, request *http.Request, response *Response[T]) error {
+	c.logger.Debug("executing DS request", "name", name, "method", request.Method, "url", redactUrl(request.URL))
 	res, err := c.client.Do(request)
 	if err != nil {
 		c.logger.Debug("Error executing request", "name", name, "error", err)
@@ -64,14 +147,17 @@ func doRequest[T any](c *Client, name string, request *http.Request, response *R
 	}
 	if !response.Success {
 		c.logger.Debug("Request error", "name", name, "code", response.Error.Code)
-		return fmt.Errorf("%s request error: code %d", name, response.Error.Code)
+		return &APIError{API: api, Code: response.Error.Code}
 	}
 	return nil
 }
 
 type LoginRequest struct {
-	user string
-	pass string
+	user        string
+	pass        string
+	otpCode     string
+	trustDevice bool
+	deviceId    string
 }
 
 type LoginResponseData struct {
@@ -79,136 +165,528 @@ type LoginResponseData struct {
 	DID string `json:"did"`
 }
 
-func (c *Client) createRequest(ctx context.Context, requestUrl string, urlParams ...string) (*http.Request, error) {
-	params := make([]any, len(urlParams)+1)
-	params[0] = c.host
-	for i, p := range urlParams {
-		params[i+1] = p
-	}
+func (c *Client) apiUrl(path string) string {
+	return c.baseUrl + "/webapi/" + path
+}
+
+func (c *Client) createRequest(ctx context.Context, path string, params url.Values) (*http.Request, error) {
+	requestUrl := c.apiUrl(path)
 	if len(params) > 0 {
-		requestUrl = fmt.Sprintf(requestUrl, params...)
+		requestUrl += "?" + params.Encode()
 	}
-	return http.NewRequestWithContext(ctx, "GET", requestUrl, nil)
+	return http.NewRequestWithContext(ctx, http.MethodGet, requestUrl, nil)
 }
 
-func (c *Client) createAuthenticatedRequest(ctx context.Context, requestUrl string, sid string, urlParams ...string) (*http.Request, error) {
-	requestUrl = requestUrl + "&_sid=" + sid
-	return c.createRequest(ctx, requestUrl, urlParams...)
+func (c *Client) createAuthenticatedRequest(ctx context.Context, path string, sid string, params url.Values) (*http.Request, error) {
+	if params == nil {
+		params = url.Values{}
+	}
+	params.Set("_sid", sid)
+	return c.createRequest(ctx, path, params)
+}
+
+// createPostRequest sends the parameters as a form in the request body, so
+// they don't end up in the NAS access logs.
+func (c *Client) createPostRequest(ctx context.Context, path string, params url.Values) (*http.Request, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, c.apiUrl(path), strings.NewReader(params.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return request, nil
+}
+
+// FileUpload is a file sent as a part of a multipart request.
+type FileUpload struct {
+	Field    string
+	FileName string
+	Content  io.Reader
+}
+
+// createMultipartRequest sends the parameters as multipart form fields
+// followed by the file, Download Station expects the file to be the last
+// part of the form.
+func (c *Client) createMultipartRequest(ctx context.Context, path string, params url.Values, upload FileUpload) (*http.Request, error) {
+	body := new(bytes.Buffer)
+	form := multipart.NewWriter(body)
+	for _, name := range slices.Sorted(maps.Keys(params)) {
+		for _, value := range params[name] {
+			if err := form.WriteField(name, value); err != nil {
+				return nil, err
+			}
+		}
+	}
+	part, err := form.CreateFormFile(upload.Field, upload.FileName)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = io.Copy(part, upload.Content); err != nil {
+		return nil, err
+	}
+	if err = form.Close(); err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, c.apiUrl(path), body)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", form.FormDataContentType())
+	return request, nil
+}
+
+// redactUrl returns the url as a string with the sensitive query parameters
+// masked.
+func redactUrl(u *url.URL) string {
+	query := u.Query()
+	for _, param := range sensitiveParams {
+		if query.Has(param) {
+			query.Set(param, "REDACTED")
+		}
+	}
+	redacted := *u
+	redacted.RawQuery = query.Encode()
+	return redacted.String()
 }
 
 func (c *Client) Login(ctx context.Context, data LoginRequest) (*Response[LoginResponseData], error) {
-	request, err := c.createRequest(ctx, LoginUrl, data.user, data.pass)
+	params := url.Values{}
+	params.Set("account", data.user)
+	params.Set("passwd", data.pass)
+	params.Set("session", "DownloadStation")
+	params.Set("format", "sid")
+	if data.otpCode != "" {
+		params.Set("otp_code", data.otpCode)
+		if data.trustDevice {
+			params.Set("enable_device_token", "yes")
+			params.Set("device_name", DeviceName)
+		}
+	}
+	if data.deviceId != "" {
+		params.Set("device_id", data.deviceId)
+	}
+	request, err := c.newPostRequest(ctx, AuthAPI, "login", params)
 	if err != nil {
 		return nil, fmt.Errorf("creating login request: %w", err)
 	}
 	response := new(Response[LoginResponseData])
-	err = doRequest(c, "login", request, response)
+	err = doRequest(c, AuthAPI, "login", request, response)
 	if err != nil {
 		return nil, err
 	}
 	return response, nil
 }
 
+func (c *Client) Logout(ctx context.Context, sid string) error {
+	params := url.Values{"session": {"DownloadStation"}}
+	request, err := c.newRequest(ctx, AuthAPI, "logout", sid, params)
+	if err != nil {
+		return fmt.Errorf("creating logout request: %w", err)
+	}
+	var response Response[any]
+	return doRequest(c, AuthAPI, "logout", request, &response)
+}
+
 type TasksData struct {
-	Offset int `json:"offset"`
-	Tasks  []struct {
-		Additional struct {
-			Transfer struct {
-				DownloadedPieces int64 `json:"downloaded_pieces"`
-				SizeDownloaded   int64 `json:"size_downloaded"`
-				SizeUploaded     int64 `json:"size_uploaded"`
-				SpeedDownload    int64 `json:"speed_download"`
-				SpeedUpload      int64 `json:"speed_upload"`
-			} `json:"transfer"`
-		} `json:"additional"`
-		Id       string `json:"id"`
-		Size     int64  `json:"size"`
-		Status   string `json:"status"`
-		Title    string `json:"title"`
-		Type     string `json:"type"`
-		Username string `json:"username"`
-	} `json:"tasks"`
-	Total int `json:"total"`
+	Offset int    `json:"offset"`
+	Tasks  []Task `json:"tasks"`
+	Total  int    `json:"total"`
+}
+
+type Task struct {
+	Additional TaskAdditional `json:"additional"`
+	Id         string         `json:"id"`
+	Size       int64          `json:"size"`
+	Status     string         `json:"status"`
+	Title      string         `json:"title"`
+	Type       string         `json:"type"`
+	Username   string         `json:"username"`
+}
+
+// TaskAdditional holds the optional task information, only the parts listed
+// in the additional parameter of the request are filled.
+type TaskAdditional struct {
+	Detail   TaskDetail    `json:"detail"`
+	Files    []TaskFile    `json:"file"`
+	Peers    []TaskPeer    `json:"peer"`
+	Trackers []TaskTracker `json:"tracker"`
+	Transfer TaskTransfer  `json:"transfer"`
+}
+
+type TaskDetail struct {
+	CompletedTime     int64  `json:"completed_time"`
+	ConnectedLeechers int    `json:"connected_leechers"`
+	ConnectedSeeders  int    `json:"connected_seeders"`
+	CreateTime        int64  `json:"create_time"`
+	Destination       string `json:"destination"`
+	Priority          string `json:"priority"`
+	StartedTime       int64  `json:"started_time"`
+	TotalPeers        int    `json:"total_peers"`
+	Uri               string `json:"uri"`
+}
+
+func (d TaskDetail) Created() time.Time {
+	return time.Unix(d.CreateTime, 0)
+}
+
+func (d TaskDetail) Completed() time.Time {
+	return time.Unix(d.CompletedTime, 0)
+}
+
+// TaskFile is a file of a task. Index and Wanted are only reported for
+// BitTorrent tasks by the DownloadStation2 API.
+type TaskFile struct {
+	FileName       string `json:"filename"`
+	Index          int    `json:"index"`
+	Priority       string `json:"priority"`
+	Size           int64  `json:"size"`
+	SizeDownloaded int64  `json:"size_downloaded"`
+	Wanted         bool   `json:"wanted"`
+}
+
+type TaskPeer struct {
+	Address       string  `json:"address"`
+	Agent         string  `json:"agent"`
+	Progress      float64 `json:"progress"`
+	SpeedDownload int64   `json:"speed_download"`
+	SpeedUpload   int64   `json:"speed_upload"`
+}
+
+// Percentage is the peer progress, reported by Download Station between 0
+// and 1.
+func (p TaskPeer) Percentage() string {
+	return fmt.Sprintf("%.1f", p.Progress*100)
+}
+
+type TaskTracker struct {
+	Peers       int    `json:"peers"`
+	Seeds       int    `json:"seeds"`
+	Status      string `json:"status"`
+	UpdateTimer int    `json:"update_timer"`
+	Url         string `json:"url"`
+}
+
+type TaskTransfer struct {
+	DownloadedPieces int64 `json:"downloaded_pieces"`
+	SizeDownloaded   int64 `json:"size_downloaded"`
+	SizeUploaded     int64 `json:"size_uploaded"`
+	SpeedDownload    int64 `json:"speed_download"`
+	SpeedUpload      int64 `json:"speed_upload"`
+}
+
+// usesTaskV2 tells whether tasks are managed through the DownloadStation2
+// API, preferred when the NAS exposes it.
+func (c *Client) usesTaskV2(ctx context.Context) bool {
+	_, err := c.endpoint(ctx, Task2API)
+	return err == nil
 }
 
 func (c *Client) GetTasks(ctx context.Context, sid string, response *Response[TasksData]) error {
-	request, err := c.createAuthenticatedRequest(ctx, TasksUrl, sid)
+	return c.GetTasksPage(ctx, sid, TasksPage{}, response)
+}
+
+// TasksPage is a slice of the tasks list, a zero Limit means all the tasks
+// from Offset.
+type TasksPage struct {
+	Offset int
+	Limit  int
+}
+
+func (p TasksPage) params(params url.Values) url.Values {
+	if p.Offset > 0 {
+		params.Set("offset", strconv.Itoa(p.Offset))
+	}
+	if p.Limit > 0 {
+		params.Set("limit", strconv.Itoa(p.Limit))
+	}
+	return params
+}
+
+// GetTasksPage lists a page of the tasks, Total in the response counts all
+// of them.
+func (c *Client) GetTasksPage(ctx context.Context, sid string, page TasksPage, response *Response[TasksData]) error {
+	if c.usesTaskV2(ctx) {
+		return c.getTasksV2(ctx, sid, page, response)
+	}
+	params := page.params(url.Values{"additional": {"transfer"}})
+	request, err := c.newRequest(ctx, TaskAPI, "list", sid, params)
 	if err != nil {
 		return fmt.Errorf("creating tasks request: %w", err)
 	}
-	err = doRequest(c, "tasks", request, response)
+	err = doRequest(c, TaskAPI, "tasks", request, response)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// taskInfoAdditional is all the additional information shown in the task
+// page.
+var taskInfoAdditional = []string{"detail", "file", "peer", "tracker", "transfer"}
+
+// GetTask returns a task with all its additional information.
+func (c *Client) GetTask(ctx context.Context, sid string, id string) (*Task, error) {
+	if c.usesTaskV2(ctx) {
+		return c.getTaskV2(ctx, sid, id)
+	}
+	params := url.Values{
+		"id":         {id},
+		"additional": {strings.Join(taskInfoAdditional, ",")},
+	}
+	request, err := c.newRequest(ctx, TaskAPI, "getinfo", sid, params)
+	if err != nil {
+		return nil, fmt.Errorf("creating task info request: %w", err)
+	}
+	var response Response[TasksData]
+	err = doRequest(c, TaskAPI, "task info", request, &response)
+	if err != nil {
+		return nil, err
+	}
+	if len(response.Data.Tasks) == 0 {
+		return nil, &APIError{API: TaskAPI, Code: 404}
+	}
+	return &response.Data.Tasks[0], nil
+}
+
+// TaskCreateRequest creates a task for each of the Uris or, when set, from
+// the .torrent or .nzb File. The default destination is used when
+// Destination is empty.
 type TaskCreateRequest struct {
-	Uri string
+	Uris        []string
+	File        io.Reader
+	FileName    string
+	Destination string
+}
+
+// joinUris builds the comma separated uri list of the create method. Commas
+// inside a uri are percent encoded so they aren't taken as separators.
+func joinUris(uris []string) string {
+	escaped := make([]string, len(uris))
+	for i, uri := range uris {
+		escaped[i] = strings.ReplaceAll(uri, ",", "%2C")
+	}
+	return strings.Join(escaped, ",")
 }
 
 func (c *Client) CreateTask(ctx context.Context, sid string, data TaskCreateRequest) (*Response[any], error) {
-	request, err := c.createAuthenticatedRequest(ctx, CreateTaskUrl, sid, data.Uri)
+	if c.usesTaskV2(ctx) {
+		return c.createTaskV2(ctx, sid, data)
+	}
+	params := url.Values{}
+	if data.Destination != "" {
+		params.Set("destination", data.Destination)
+	}
+	var request *http.Request
+	var err error
+	if data.File != nil {
+		upload := FileUpload{Field: "file", FileName: data.FileName, Content: data.File}
+		request, err = c.newMultipartRequest(ctx, TaskAPI, "create", sid, params, upload)
+	} else {
+		params.Set("uri", joinUris(data.Uris))
+		request, err = c.newRequest(ctx, TaskAPI, "create", sid, params)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("creating new task request: %w", err)
 	}
 	var response Response[any]
-	err = doRequest(c, "new task", request, &response)
+	err = doRequest(c, TaskAPI, "new task", request, &response)
 	if err != nil {
 		return nil, err
 	}
 	return &response, nil
 }
 
-type TaskChangeData []struct {
+type TaskChangeData []TaskChange
+
+type TaskChange struct {
 	Id    string `json:"id"`
 	Error int    `json:"error"`
 }
 
-func (c *Client) DeleteTask(ctx context.Context, sid string, id string) error {
-	request, err := c.createAuthenticatedRequest(ctx, DeleteTaskUrl, sid, id)
+// Err returns the TaskErrors of the failed tasks, nil when all the tasks
+// were changed.
+func (d TaskChangeData) Err() error {
+	return d.apiErr(TaskAPI)
+}
+
+// apiErr is like Err for the changes reported by another API.
+func (d TaskChangeData) apiErr(api string) error {
+	var errs TaskErrors
+	for _, change := range d {
+		if change.Error != 0 {
+			errs = append(errs, &TaskError{Id: change.Id, Err: &APIError{API: api, Code: change.Error}})
+		}
+	}
+	if errs == nil {
+		return nil
+	}
+	return errs
+}
+
+// EditTask moves a task to another destination folder.
+func (c *Client) EditTask(ctx context.Context, sid string, id string, destination string) error {
+	if c.usesTaskV2(ctx) {
+		return c.editTaskV2(ctx, sid, id, destination)
+	}
+	params := url.Values{
+		"id":          {id},
+		"destination": {destination},
+	}
+	request, err := c.newRequest(ctx, TaskAPI, "edit", sid, params)
 	if err != nil {
-		return fmt.Errorf("creating tasks request: %w", err)
+		return fmt.Errorf("creating task edit request: %w", err)
 	}
 	var response Response[TaskChangeData]
-	err = doRequest(c, "task delete", request, &response)
+	err = doRequest(c, TaskAPI, "task edit", request, &response)
 	if err != nil {
 		return err
 	}
-	if response.Error.Code != 0 {
-		return fmt.Errorf("error deleting task: %d", response.Error.Code)
-	}
-	return nil
+	return response.Data.Err()
+}
+
+// DeleteTask deletes a single task, see DeleteTasks.
+func (c *Client) DeleteTask(ctx context.Context, sid string, id string, options DeleteOptions) error {
+	_, err := c.DeleteTasks(ctx, sid, []string{id}, options)
+	return err
 }
 
 func (c *Client) PauseTask(ctx context.Context, sid string, id string) error {
-	request, err := c.createAuthenticatedRequest(ctx, PauseTaskUrl, sid, id)
-	if err != nil {
-		return fmt.Errorf("creating tasks request: %w", err)
+	_, err := c.PauseTasks(ctx, sid, []string{id})
+	return err
+}
+
+func (c *Client) ResumeTask(ctx context.Context, sid string, id string) error {
+	_, err := c.ResumeTasks(ctx, sid, []string{id})
+	return err
+}
+
+// DeleteOptions choose what happens to the files of the deleted tasks.
+// ForceComplete keeps the files downloaded so far by moving them to the
+// destination, otherwise the incomplete files are removed. RemoveFiles also
+// deletes the data of the completed tasks from the destination folder.
+type DeleteOptions struct {
+	ForceComplete bool
+	RemoveFiles   bool
+}
+
+// completeStatuses are the statuses of the tasks whose data is all in the
+// destination folder.
+var completeStatuses = []string{"finished", "seeding"}
+
+// DeleteTasks deletes the tasks with a single request and returns the
+// outcome of each of them. When some of the tasks can't be deleted the error
+// is a TaskErrors.
+func (c *Client) DeleteTasks(ctx context.Context, sid string, ids []string, options DeleteOptions) (TaskChangeData, error) {
+	var paths map[string]string
+	var unsafe []error
+	if options.RemoveFiles {
+		var err error
+		paths, unsafe, err = c.taskDataPaths(ctx, sid, ids)
+		if err != nil {
+			return nil, err
+		}
 	}
-	var response Response[TaskChangeData]
-	err = doRequest(c, "task pause", request, &response)
-	if err != nil {
-		return err
+	params := url.Values{"force_complete": {strconv.FormatBool(options.ForceComplete && !options.RemoveFiles)}}
+	results, err := c.changeTasks(ctx, sid, "delete", ids, params)
+	var taskErrs TaskErrors
+	if err != nil && !errors.As(err, &taskErrs) {
+		return nil, err
 	}
-	if response.Error.Code != 0 {
-		return fmt.Errorf("error pausing task: %d", response.Error.Code)
+	err = errors.Join(append([]error{err}, unsafe...)...)
+	if len(paths) == 0 {
+		return results, err
 	}
-	return nil
+
+	var deleted []string
+	for _, result := range results {
+		if dataPath, found := paths[result.Id]; found && result.Error == 0 {
+			deleted = append(deleted, dataPath)
+		}
+	}
+	if len(deleted) == 0 {
+		return results, err
+	}
+	if filesErr := c.DeleteFiles(ctx, sid, deleted); filesErr != nil {
+		return results, errors.Join(err, fmt.Errorf("deleting the files of the tasks: %w", filesErr))
+	}
+	return results, err
 }
 
-func (c *Client) ResumeTask(ctx context.Context, sid string, id string) error {
-	request, err := c.createAuthenticatedRequest(ctx, ResumeTaskUrl, sid, id)
+// ErrUnsafeDataPath is returned when the data of a task can't be removed
+// because its title would point outside of its destination folder.
+var ErrUnsafeDataPath = errors.New("task data path outside of the destination")
+
+// taskDataPaths returns the File Station paths of the data of the complete
+// tasks by id. The titles come from the torrents and aren't trusted, the
+// tasks whose path would escape the destination are reported as unsafe and
+// their files are left alone.
+func (c *Client) taskDataPaths(ctx context.Context, sid string, ids []string) (map[string]string, []error, error) {
+	paths := make(map[string]string)
+	var unsafe []error
+	for _, id := range ids {
+		task, err := c.GetTask(ctx, sid, id)
+		if err != nil {
+			return nil, nil, err
+		}
+		destination := task.Additional.Detail.Destination
+		if !slices.Contains(completeStatuses, task.Status) || destination == "" || task.Title == "" {
+			continue
+		}
+		dataPath, ok := taskDataPath(destination, task.Title)
+		if !ok {
+			c.logger.Warn("refusing to delete task data", "id", id, "destination", destination, "title", task.Title)
+			unsafe = append(unsafe, fmt.Errorf("task %s: %w", id, ErrUnsafeDataPath))
+			continue
+		}
+		paths[id] = dataPath
+	}
+	return paths, unsafe, nil
+}
+
+// taskDataPath is the path of the file or folder named title right inside
+// the destination folder, which must be a shared folder or one of its
+// subfolders.
+func taskDataPath(destination string, title string) (string, bool) {
+	if title == "." || title == ".." || strings.ContainsAny(title, "/\\") {
+		return "", false
+	}
+	folder := path.Clean("/" + destination)
+	if folder == "/" {
+		return "", false
+	}
+	dataPath := path.Clean(folder + "/" + title)
+	if path.Dir(dataPath) != folder {
+		return "", false
+	}
+	return dataPath, true
+}
+
+func (c *Client) PauseTasks(ctx context.Context, sid string, ids []string) (TaskChangeData, error) {
+	return c.changeTasks(ctx, sid, "pause", ids, nil)
+}
+
+func (c *Client) ResumeTasks(ctx context.Context, sid string, ids []string) (TaskChangeData, error) {
+	return c.changeTasks(ctx, sid, "resume", ids, nil)
+}
+
+// changeTasks runs one of the delete, pause and resume methods on a list of
+// tasks. The outcome of every task is returned along with the TaskErrors of
+// the failed ones.
+func (c *Client) changeTasks(ctx context.Context, sid string, method string, ids []string, params url.Values) (TaskChangeData, error) {
+	if c.usesTaskV2(ctx) {
+		return c.changeTasksV2(ctx, sid, method, ids, params)
+	}
+	if params == nil {
+		params = url.Values{}
+	}
+	params.Set("id", strings.Join(ids, ","))
+	request, err := c.newRequest(ctx, TaskAPI, method, sid, params)
 	if err != nil {
-		return fmt.Errorf("creating tasks request: %w", err)
+		return nil, fmt.Errorf("creating tasks request: %w", err)
 	}
 	var response Response[TaskChangeData]
-	err = doRequest(c, "task resume", request, &response)
+	err = doRequest(c, TaskAPI, "task "+method, request, &response)
 	if err != nil {
-		return err
-	}
-	if response.Error.Code != 0 {
-		return fmt.Errorf("error resuming task: %d", response.Error.Code)
+		return nil, err
 	}
-	return nil
+	return response.Data, response.Data.Err()
 }
@@ -2,25 +2,40 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
 
 const (
-	ExpectedLoginUrl      = "/webapi/entry.cgi?api=SYNO.API.Auth&version=6&method=login&account=user&passwd=pass&session=DownloadStation&format=sid"
-	ExpectedTasksUrl      = "/webapi/DownloadStation/task.cgi?api=SYNO.DownloadStation.Task&version=1&method=list&additional=transfer&_sid=SID"
-	ExpectedDeleteTaskUrl = "/webapi/DownloadStation/task.cgi?api=SYNO.DownloadStation.Task&version=1&method=delete&id=ID1&_sid=SID"
-	ExpectedPauseTaskUrl  = "/webapi/DownloadStation/task.cgi?api=SYNO.DownloadStation.Task&version=1&method=pause&id=ID1&_sid=SID"
-	ExpectedResumeTaskUrl = "/webapi/DownloadStation/task.cgi?api=SYNO.DownloadStation.Task&version=1&method=resume&id=ID1&_sid=SID"
+	ExpectedLoginUrl      = "/webapi/entry.cgi"
+	ExpectedLoginBody     = "account=user&api=SYNO.API.Auth&format=sid&method=login&passwd=p%26ss%23%25&session=DownloadStation&version=6"
+	ExpectedLoginOTPBody  = "account=user&api=SYNO.API.Auth&device_name=Downtown&enable_device_token=yes&format=sid&method=login&otp_code=123456&passwd=p%26ss%23%25&session=DownloadStation&version=6"
+	ExpectedLogoutUrl     = "/webapi/entry.cgi?_sid=SID&api=SYNO.API.Auth&method=logout&session=DownloadStation&version=6"
+	ExpectedTasksUrl      = "/webapi/DownloadStation/task.cgi?_sid=SID&additional=transfer&api=SYNO.DownloadStation.Task&method=list&version=3"
+	ExpectedTaskUrl       = "/webapi/DownloadStation/task.cgi?_sid=SID&additional=detail%2Cfile%2Cpeer%2Ctracker%2Ctransfer&api=SYNO.DownloadStation.Task&id=ID1&method=getinfo&version=3"
+	ExpectedCreateTaskUrl = "/webapi/DownloadStation/task.cgi?_sid=SID&api=SYNO.DownloadStation.Task&method=create&uri=https%3A%2F%2Fexample.com%2Ffile%3Fa%3D1%26b%3D2%2Cmagnet%3A%3Fxt%3Durn%3Abtih%3AABC%26dn%3Da%252Cb&version=3"
+	ExpectedEditTaskUrl   = "/webapi/DownloadStation/task.cgi?_sid=SID&api=SYNO.DownloadStation.Task&destination=video%2FMovies&id=ID1&method=edit&version=3"
+	ExpectedDeleteTaskUrl = "/webapi/DownloadStation/task.cgi?_sid=SID&api=SYNO.DownloadStation.Task&force_complete=false&id=ID1&method=delete&version=3"
+	ExpectedPauseTaskUrl  = "/webapi/DownloadStation/task.cgi?_sid=SID&api=SYNO.DownloadStation.Task&id=ID1&method=pause&version=3"
+	ExpectedResumeTaskUrl = "/webapi/DownloadStation/task.cgi?_sid=SID&api=SYNO.DownloadStation.Task&id=ID1&method=resume&version=3"
 )
 
 func TestSuccessfulDoRequest(t *testing.T) {
 	c, s := testClient(func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.RequestURI() != "/test-request" {
-			t.Errorf("requests url '%s' used but expected '%s'", r.URL.RequestURI(), "/test-request")
+		if r.URL.RequestURI() != "/webapi/test-request" {
+			t.Errorf("requests url '%s' used but expected '%s'", r.URL.RequestURI(), "/webapi/test-request")
 		}
 
 		w.WriteHeader(http.StatusOK)
@@ -30,11 +45,11 @@ func TestSuccessfulDoRequest(t *testing.T) {
 	defer s.Close()
 
 	res := new(Response[string])
-	req, err := c.createRequest(context.Background(), "https://%s/test-request")
+	req, err := c.createRequest(context.Background(), "test-request", nil)
 	if err != nil {
 		t.Fatalf("failed to create request: %v", err)
 	}
-	err = doRequest(c, "test request", req, res)
+	err = doRequest(c, "SYNO.Test", "test request", req, res)
 	if err != nil {
 		t.Error(err)
 	}
@@ -42,8 +57,8 @@ func TestSuccessfulDoRequest(t *testing.T) {
 
 func TestUnsuccessfulDoRequest(t *testing.T) {
 	c, s := testClient(func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.RequestURI() != "/test-request" {
-			t.Errorf("requests url '%s' used but expected '%s'", r.URL.RequestURI(), "/test-request")
+		if r.URL.RequestURI() != "/webapi/test-request" {
+			t.Errorf("requests url '%s' used but expected '%s'", r.URL.RequestURI(), "/webapi/test-request")
 		}
 
 		w.WriteHeader(http.StatusOK)
@@ -53,24 +68,45 @@ func TestUnsuccessfulDoRequest(t *testing.T) {
 	defer s.Close()
 
 	res := new(Response[string])
-	req, err := c.createRequest(context.Background(), "https://%s/test-request")
+	req, err := c.createRequest(context.Background(), "test-request", nil)
 	if err != nil {
 		t.Fatalf("failed to create request: %v", err)
 	}
-	err = doRequest(c, "test request", req, res)
+	err = doRequest(c, "SYNO.Test", "test request", req, res)
 	if err == nil {
 		t.Fatal("error expected when response has success field to false")
 	}
-	if err.Error() != "test request request error: code 1" {
-		t.Errorf("error message '%s' expected 'test request request error: code 1'", err.Error())
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.API != "SYNO.Test" || apiErr.Code != 1 {
+		t.Fatalf("APIError with code 1 expected, got '%v'", err)
+	}
+	if err.Error() != "SYNO.Test error 1: Unknown error" {
+		t.Errorf("error message '%s' expected 'SYNO.Test error 1: Unknown error'", err.Error())
+	}
+}
+
+func TestSessionExpiredDoRequest(t *testing.T) {
+	c, s := testClient(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		data := []byte("{\"data\":null,\"success\":false,\"error\":{\"code\":106}}")
+		_, _ = w.Write(data)
+	})
+	defer s.Close()
+
+	res := new(Response[string])
+	req, err := c.createRequest(context.Background(), "test-request", nil)
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	err = doRequest(c, "SYNO.Test", "test request", req, res)
+	if !errors.Is(err, ErrSessionExpired) {
+		t.Errorf("error '%v' expected to be ErrSessionExpired", err)
 	}
 }
 
 func TestLogin(t *testing.T) {
 	c, s := testClient(func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.RequestURI() != ExpectedLoginUrl {
-			t.Errorf("login url '%s' used but expected '%s'", r.URL.RequestURI(), ExpectedLoginUrl)
-		}
+		assertLoginRequest(t, r, ExpectedLoginBody)
 
 		w.WriteHeader(http.StatusOK)
 		data := []byte("{\"data\":{\"did\":\"DIDDID\",\"is_portal_port\":false,\"sid\":\"SIDDIS\"},\"success\":true}")
@@ -80,19 +116,79 @@ func TestLogin(t *testing.T) {
 
 	res, err := c.Login(context.Background(), LoginRequest{
 		user: "user",
-		pass: "pass",
+		pass: "p&ss#%",
 	})
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
 	if res.Data.SID != "SIDDIS" {
 		t.Errorf("login sid is '%s' while 'SIDDIS' expected", res.Data.SID)
 	}
 }
 
+func TestLoginOTPRequired(t *testing.T) {
+	c, s := testClient(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"error":{"code":403},"success":false}`))
+	})
+	defer s.Close()
+
+	_, err := c.Login(context.Background(), LoginRequest{
+		user: "user",
+		pass: "pass",
+	})
+	if !errors.Is(err, ErrOTPRequired) {
+		t.Errorf("error '%v' expected to be ErrOTPRequired", err)
+	}
+}
+
+func TestLoginWithOTP(t *testing.T) {
+	c, s := testClient(func(w http.ResponseWriter, r *http.Request) {
+		assertLoginRequest(t, r, ExpectedLoginOTPBody)
+
+		w.WriteHeader(http.StatusOK)
+		data := []byte("{\"data\":{\"did\":\"DIDDID\",\"is_portal_port\":false,\"sid\":\"SIDDIS\"},\"success\":true}")
+		_, _ = w.Write(data)
+	})
+	defer s.Close()
+
+	res, err := c.Login(context.Background(), LoginRequest{
+		user:        "user",
+		pass:        "p&ss#%",
+		otpCode:     "123456",
+		trustDevice: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Data.DID != "DIDDID" {
+		t.Errorf("login did is '%s' while 'DIDDID' expected", res.Data.DID)
+	}
+}
+
+func TestLogout(t *testing.T) {
+	c, s := testClient(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.RequestURI() != ExpectedLogoutUrl {
+			t.Errorf("logout url '%s' used but expected '%s'", r.URL.RequestURI(), ExpectedLogoutUrl)
+		}
+
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"success":true}`))
+	})
+	defer s.Close()
+
+	err := c.Logout(context.Background(), "SID")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
 func TestCreateAuthenticatedRequest(t *testing.T) {
-	c := NewClient("localhost", slog.Default())
-	req, err := c.createAuthenticatedRequest(context.Background(), "https://%s/test-request?", "SID")
+	c, err := NewClient(ClientConfig{Host: "localhost"}, slog.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := c.createAuthenticatedRequest(context.Background(), "test-request", "SID", nil)
 	if err != nil {
 		t.Fatalf("failed to create authenticated request: %v", err)
 	}
@@ -120,6 +216,131 @@ func TestTasks(t *testing.T) {
 	}
 }
 
+func TestTasksPage(t *testing.T) {
+	c, s := testClient(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("offset") != "50" || r.FormValue("limit") != "25" {
+			t.Errorf("offset '%s' and limit '%s' sent while 50 and 25 expected", r.FormValue("offset"), r.FormValue("limit"))
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"data":{"offset":50,"tasks":[],"total":60},"success":true}`))
+	})
+	defer s.Close()
+
+	var response Response[TasksData]
+	err := c.GetTasksPage(context.Background(), "SID", TasksPage{Offset: 50, Limit: 25}, &response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Data.Offset != 50 || response.Data.Total != 60 {
+		t.Errorf("page %+v not decoded as expected", response.Data)
+	}
+}
+
+func TestTask(t *testing.T) {
+	c, s := testClient(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.RequestURI() != ExpectedTaskUrl {
+			t.Errorf("url '%s' used but expected '%s'", r.URL.RequestURI(), ExpectedTaskUrl)
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"data":{"tasks":[{"additional":{"detail":{"create_time":1714560000,"destination":"downloads","uri":"https://example.com/a.iso"},"file":[{"filename":"a.iso","priority":"normal","size":100,"size_downloaded":50}]},"id":"ID1","size":100,"status":"downloading","title":"a.iso","type":"http"}]},"success":true}`))
+	})
+	defer s.Close()
+
+	task, err := c.GetTask(context.Background(), "SID", "ID1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.Id != "ID1" || task.Additional.Detail.Destination != "downloads" || task.Additional.Detail.Created().Unix() != 1714560000 {
+		t.Errorf("task %+v not decoded as expected", task)
+	}
+	if len(task.Additional.Files) != 1 || task.Additional.Files[0].SizeDownloaded != 50 {
+		t.Errorf("task files %+v not decoded as expected", task.Additional.Files)
+	}
+}
+
+func TestTaskNotFound(t *testing.T) {
+	c, s := testClient(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"data":{"tasks":[]},"success":true}`))
+	})
+	defer s.Close()
+
+	_, err := c.GetTask(context.Background(), "SID", "ID1")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Code != 404 {
+		t.Errorf("invalid task id error expected, got %v", err)
+	}
+}
+
+func TestCreateTask(t *testing.T) {
+	c, s := testClient(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.RequestURI() != ExpectedCreateTaskUrl {
+			t.Errorf("create task url '%s' used but expected '%s'", r.URL.RequestURI(), ExpectedCreateTaskUrl)
+		}
+
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"success":true}`))
+	})
+	defer s.Close()
+
+	_, err := c.CreateTask(context.Background(), "SID", TaskCreateRequest{Uris: []string{
+		"https://example.com/file?a=1&b=2",
+		"magnet:?xt=urn:btih:ABC&dn=a,b",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCreateTaskFile(t *testing.T) {
+	c, s := testClient(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/webapi/DownloadStation/task.cgi" {
+			t.Errorf("create task request '%s %s' used but expected 'POST /webapi/DownloadStation/task.cgi'", r.Method, r.URL.Path)
+		}
+		for param, value := range map[string]string{"api": TaskAPI, "method": "create", "_sid": "SID"} {
+			if r.FormValue(param) != value {
+				t.Errorf("create task parameter %s is '%s' while '%s' expected", param, r.FormValue(param), value)
+			}
+		}
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			t.Fatalf("file part expected: %v", err)
+		}
+		content, _ := io.ReadAll(file)
+		if header.Filename != "debian.torrent" || string(content) != "d8:announce" {
+			t.Errorf("file '%s' with content '%s' uploaded", header.Filename, content)
+		}
+
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"success":true}`))
+	})
+	defer s.Close()
+
+	_, err := c.CreateTask(context.Background(), "SID", TaskCreateRequest{
+		File:     strings.NewReader("d8:announce"),
+		FileName: "debian.torrent",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEditTask(t *testing.T) {
+	c, s := testClient(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.RequestURI() != ExpectedEditTaskUrl {
+			t.Errorf("url '%s' used but expected '%s'", r.URL.RequestURI(), ExpectedEditTaskUrl)
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"data":[{"error":0,"id":"ID1"}],"success":true}`))
+	})
+	defer s.Close()
+
+	err := c.EditTask(context.Background(), "SID", "ID1", "video/Movies")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestDeleteTask(t *testing.T) {
 	c, s := testClient(func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.RequestURI() != ExpectedDeleteTaskUrl {
@@ -132,12 +353,128 @@ func TestDeleteTask(t *testing.T) {
 	})
 	defer s.Close()
 
-	err := c.DeleteTask(context.Background(), "SID", "ID1")
+	err := c.DeleteTask(context.Background(), "SID", "ID1", DeleteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeleteTaskKeepFiles(t *testing.T) {
+	c, s := testClient(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("force_complete") != "true" {
+			t.Errorf("force_complete '%s' sent while 'true' expected", r.FormValue("force_complete"))
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"data":[{"id":"ID1","error":0}],"success":true}`))
+	})
+	defer s.Close()
+
+	err := c.DeleteTask(context.Background(), "SID", "ID1", DeleteOptions{ForceComplete: true})
 	if err != nil {
 		t.Fatal(err)
 	}
 }
 
+func TestDeleteTasksRemoveFiles(t *testing.T) {
+	var deletedPaths string
+	c, s := testClient(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		switch r.FormValue("api") + " " + r.FormValue("method") {
+		case "SYNO.DownloadStation.Task getinfo":
+			status := "finished"
+			if r.FormValue("id") == "ID3" {
+				status = "downloading"
+			}
+			_, _ = fmt.Fprintf(w, `{"data":{"tasks":[{"additional":{"detail":{"destination":"video/Movies"}},"id":"%s","status":"%s","title":"%s.mkv"}]},"success":true}`,
+				r.FormValue("id"), status, r.FormValue("id"))
+		case "SYNO.DownloadStation.Task delete":
+			if r.FormValue("force_complete") != "false" {
+				t.Errorf("force_complete '%s' sent while 'false' expected", r.FormValue("force_complete"))
+			}
+			_, _ = w.Write([]byte(`{"data":[{"id":"ID1","error":0},{"id":"ID2","error":405},{"id":"ID3","error":0}],"success":true}`))
+		case "SYNO.FileStation.Delete delete":
+			deletedPaths = r.FormValue("path")
+			if r.FormValue("recursive") != "true" {
+				t.Error("recursive delete expected")
+			}
+			_, _ = w.Write([]byte(`{"success":true}`))
+		default:
+			t.Errorf("unexpected request %s", r.URL.RequestURI())
+		}
+	})
+	defer s.Close()
+
+	results, err := c.DeleteTasks(context.Background(), "SID", []string{"ID1", "ID2", "ID3"}, DeleteOptions{ForceComplete: true, RemoveFiles: true})
+	var taskErrs TaskErrors
+	if !errors.As(err, &taskErrs) || len(taskErrs) != 1 || taskErrs[0].Id != "ID2" {
+		t.Errorf("ID2 failure expected, got %v", err)
+	}
+	if len(results) != 3 {
+		t.Errorf("3 results expected, got %v", results)
+	}
+	// ID2 wasn't deleted and ID3 wasn't complete, its files are removed by
+	// Download Station
+	expected := `["/video/Movies/ID1.mkv"]`
+	if deletedPaths != expected {
+		t.Errorf("paths '%s' deleted while '%s' expected", deletedPaths, expected)
+	}
+}
+
+func TestDeleteTasksRemoveFilesHostileTitle(t *testing.T) {
+	var deletedPaths string
+	c, s := testClient(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		switch r.FormValue("api") + " " + r.FormValue("method") {
+		case "SYNO.DownloadStation.Task getinfo":
+			title := map[string]string{"ID1": "..", "ID2": "../../volume1", "ID3": "ok.mkv"}[r.FormValue("id")]
+			_, _ = fmt.Fprintf(w, `{"data":{"tasks":[{"additional":{"detail":{"destination":"video"}},"id":"%s","status":"finished","title":"%s"}]},"success":true}`,
+				r.FormValue("id"), title)
+		case "SYNO.DownloadStation.Task delete":
+			_, _ = w.Write([]byte(`{"data":[{"id":"ID1","error":0},{"id":"ID2","error":0},{"id":"ID3","error":0}],"success":true}`))
+		case "SYNO.FileStation.Delete delete":
+			deletedPaths = r.FormValue("path")
+			_, _ = w.Write([]byte(`{"success":true}`))
+		default:
+			t.Errorf("unexpected request %s", r.URL.RequestURI())
+		}
+	})
+	defer s.Close()
+
+	_, err := c.DeleteTasks(context.Background(), "SID", []string{"ID1", "ID2", "ID3"}, DeleteOptions{RemoveFiles: true})
+	if !errors.Is(err, ErrUnsafeDataPath) {
+		t.Errorf("unsafe data path error expected, got %v", err)
+	}
+	expected := `["/video/ok.mkv"]`
+	if deletedPaths != expected {
+		t.Errorf("paths '%s' deleted while '%s' expected", deletedPaths, expected)
+	}
+}
+
+func TestTaskDataPath(t *testing.T) {
+	testCases := []struct {
+		destination string
+		title       string
+		expected    string
+	}{
+		{"video/Movies", "a.mkv", "/video/Movies/a.mkv"},
+		{"/video/Movies/", "a b", "/video/Movies/a b"},
+		{"video", "..", ""},
+		{"video", ".", ""},
+		{"video", "../music", ""},
+		{"video", "a/b", ""},
+		{"video", `a\b`, ""},
+		{"video/..", "music", ""},
+		{"/", "video", ""},
+	}
+
+	for _, tc := range testCases {
+		result, ok := taskDataPath(tc.destination, tc.title)
+		if result != tc.expected || ok != (tc.expected != "") {
+			t.Errorf("path of '%s' in '%s' is '%s' while '%s' expected", tc.title, tc.destination, result, tc.expected)
+		}
+	}
+}
+
 func TestPauseTask(t *testing.T) {
 	c, s := testClient(func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.RequestURI() != ExpectedPauseTaskUrl {
@@ -156,6 +493,40 @@ func TestPauseTask(t *testing.T) {
 	}
 }
 
+func TestPauseTasks(t *testing.T) {
+	c, s := testClient(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("id") != "ID1,ID2" {
+			t.Errorf("pause id '%s' sent while 'ID1,ID2' expected", r.FormValue("id"))
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"data":[{"id":"ID1","error":0},{"id":"ID2","error":405}],"success":true}`))
+	})
+	defer s.Close()
+
+	results, err := c.PauseTasks(context.Background(), "SID", []string{"ID1", "ID2"})
+	if len(results) != 2 || results[0].Error != 0 || results[1].Id != "ID2" || results[1].Error != 405 {
+		t.Errorf("results %v not decoded as expected", results)
+	}
+	var taskErr *TaskError
+	if !errors.As(err, &taskErr) || taskErr.Id != "ID2" || taskErr.Err.Code != 405 {
+		t.Errorf("ID2 error 405 expected, got %v", err)
+	}
+}
+
+func TestPauseTaskFailed(t *testing.T) {
+	c, s := testClient(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"data":[{"id":"ID1","error":405}],"success":true}`))
+	})
+	defer s.Close()
+
+	err := c.PauseTask(context.Background(), "SID", "ID1")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Code != 405 {
+		t.Errorf("invalid task action error expected, got %v", err)
+	}
+}
+
 func TestResumeTask(t *testing.T) {
 	c, s := testClient(func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.RequestURI() != ExpectedResumeTaskUrl {
@@ -174,10 +545,125 @@ func TestResumeTask(t *testing.T) {
 	}
 }
 
+func TestTLSVerification(t *testing.T) {
+	s := httptest.NewTLSServer(fakeNAS(testAPIInfo, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"success":true}`))
+	}))
+	defer s.Close()
+	host := strings.TrimPrefix(s.URL, "https://")
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	caPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.Certificate().Raw})
+	if err := os.WriteFile(caFile, caPem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	fingerprint := sha256.Sum256(s.Certificate().Raw)
+
+	testCases := []struct {
+		name   string
+		config ClientConfig
+		valid  bool
+	}{
+		{"skip verification", ClientConfig{Host: host}, true},
+		{"verify without CA", ClientConfig{Host: host, TLSVerify: true}, false},
+		{"custom CA", ClientConfig{Host: host, CAFile: caFile}, true},
+		{"custom CA wrong server name", ClientConfig{Host: host, CAFile: caFile, ServerName: "nas.local"}, false},
+		{"pinned fingerprint", ClientConfig{Host: host, CertFingerprint: hex.EncodeToString(fingerprint[:])}, true},
+		{"wrong fingerprint", ClientConfig{Host: host, CertFingerprint: strings.Repeat("00", sha256.Size)}, false},
+	}
+
+	for _, tc := range testCases {
+		c, err := NewClient(tc.config, slog.Default())
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		err = c.Logout(context.Background(), "SID")
+		if tc.valid && err != nil {
+			t.Errorf("%s: unexpected error %v", tc.name, err)
+		}
+		if !tc.valid && err == nil {
+			t.Errorf("%s: certificate verification error expected", tc.name)
+		}
+	}
+}
+
+func TestPlainHttpClient(t *testing.T) {
+	s := httptest.NewServer(fakeNAS(testAPIInfo, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"success":true}`))
+	}))
+	defer s.Close()
+
+	c, err := NewClient(ClientConfig{Host: strings.TrimPrefix(s.URL, "http://"), Scheme: "http"}, slog.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Logout(context.Background(), "SID"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestInvalidClientConfig(t *testing.T) {
+	testCases := []ClientConfig{
+		{Host: "localhost", Scheme: "ftp"},
+		{Host: "localhost", CAFile: filepath.Join(t.TempDir(), "missing.pem")},
+		{Host: "localhost", CertFingerprint: "AB:CD"},
+	}
+	for _, config := range testCases {
+		if _, err := NewClient(config, slog.Default()); err == nil {
+			t.Errorf("error expected for config %+v", config)
+		}
+	}
+}
+
+func TestRedactUrl(t *testing.T) {
+	u, _ := url.Parse("https://nas/webapi/entry.cgi?_sid=SID&api=SYNO.API.Auth&otp_code=123456&passwd=pass")
+	expected := "https://nas/webapi/entry.cgi?_sid=REDACTED&api=SYNO.API.Auth&otp_code=REDACTED&passwd=REDACTED"
+	if redacted := redactUrl(u); redacted != expected {
+		t.Errorf("redacted url is '%s' while '%s' expected", redacted, expected)
+	}
+}
+
+func assertLoginRequest(t *testing.T, r *http.Request, expectedBody string) {
+	t.Helper()
+	if r.Method != http.MethodPost || r.URL.RequestURI() != ExpectedLoginUrl {
+		t.Errorf("login request '%s %s' used but expected 'POST %s'", r.Method, r.URL.RequestURI(), ExpectedLoginUrl)
+	}
+	body, _ := io.ReadAll(r.Body)
+	if string(body) != expectedBody {
+		t.Errorf("login body '%s' sent but expected '%s'", body, expectedBody)
+	}
+}
+
+// testAPIInfo is the SYNO.API.Info response of a DSM 6 NAS.
+const testAPIInfo = `{"data":{
+	"SYNO.API.Auth":{"maxVersion":7,"minVersion":1,"path":"entry.cgi"},
+	"SYNO.DownloadStation.Task":{"maxVersion":3,"minVersion":1,"path":"DownloadStation/task.cgi"},
+	"SYNO.FileStation.Delete":{"maxVersion":2,"minVersion":1,"path":"entry.cgi"},
+	"SYNO.FileStation.List":{"maxVersion":2,"minVersion":1,"path":"entry.cgi"}
+},"success":true}`
+
+// fakeNAS answers the API discovery requests and passes the others to f.
+func fakeNAS(apiInfo string, f http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/webapi/query.cgi" {
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte(apiInfo))
+			return
+		}
+		f(w, r)
+	}
+}
+
 func testClient(f http.HandlerFunc) (*Client, *httptest.Server) {
-	ts := httptest.NewTLSServer(f)
+	ts := httptest.NewTLSServer(fakeNAS(testAPIInfo, f))
 	host := strings.TrimPrefix(ts.URL, "https://")
-	return NewClient(host, slog.Default()), ts
+	c, err := NewClient(ClientConfig{Host: host}, slog.Default())
+	if err != nil {
+		panic(err)
+	}
+	return c, ts
 }
 
 //func sequantialRequests(f ...http.HandlerFunc) http.HandlerFunc {
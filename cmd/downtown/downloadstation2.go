@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// DSM 7 exposes tasks through the DownloadStation2 APIs. Their parameters
+// are JSON encoded and the task statuses are numeric, the functions in this
+// file translate them to the DownloadStation API types.
+const (
+	Task2API    = "SYNO.DownloadStation2.Task"
+	BTFileAPI   = "SYNO.DownloadStation2.Task.BT.File"
+	LocationAPI = "SYNO.DownloadStation2.Settings.Location"
+)
+
+// FilePriorities are the download priorities of the files of a BitTorrent
+// task.
+var FilePriorities = []string{"low", "normal", "high"}
+
+var task2Statuses = map[int]string{
+	1:  "waiting",
+	2:  "downloading",
+	3:  "paused",
+	4:  "finishing",
+	5:  "finished",
+	6:  "hash_checking",
+	7:  "pre_seeding",
+	8:  "seeding",
+	9:  "filehosting_waiting",
+	10: "extracting",
+	11: "preprocessing",
+	12: "preprocesspass",
+	13: "downloaded",
+	14: "postprocessing",
+	15: "captcha_needed",
+}
+
+// task2Status decodes both the numeric statuses and the named ones.
+type task2Status string
+
+func (s *task2Status) UnmarshalJSON(data []byte) error {
+	var code int
+	if err := json.Unmarshal(data, &code); err == nil {
+		name, found := task2Statuses[code]
+		if !found {
+			name = "error"
+		}
+		*s = task2Status(name)
+		return nil
+	}
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return fmt.Errorf("decoding task status: %w", err)
+	}
+	*s = task2Status(name)
+	return nil
+}
+
+type task2 struct {
+	Additional TaskAdditional `json:"additional"`
+	Id         string         `json:"id"`
+	Size       int64          `json:"size"`
+	Status     task2Status    `json:"status"`
+	Title      string         `json:"title"`
+	Type       string         `json:"type"`
+	Username   string         `json:"username"`
+}
+
+func (t task2) task() Task {
+	return Task{
+		Additional: t.Additional,
+		Id:         t.Id,
+		Size:       t.Size,
+		Status:     string(t.Status),
+		Title:      t.Title,
+		Type:       t.Type,
+		Username:   t.Username,
+	}
+}
+
+type tasks2Data struct {
+	Offset int     `json:"offset"`
+	Task   []task2 `json:"task"`
+	Total  int     `json:"total"`
+}
+
+type task2ChangeData struct {
+	FailedTask TaskChangeData `json:"failed_task"`
+}
+
+// jsonParam encodes a DownloadStation2 request parameter.
+func jsonParam(value any) string {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		panic("encoding request parameter: " + err.Error())
+	}
+	return string(encoded)
+}
+
+func (c *Client) getTasksV2(ctx context.Context, sid string, page TasksPage, response *Response[TasksData]) error {
+	params := page.params(url.Values{"additional": {jsonParam([]string{"transfer"})}})
+	request, err := c.newRequest(ctx, Task2API, "list", sid, params)
+	if err != nil {
+		return fmt.Errorf("creating tasks request: %w", err)
+	}
+	var response2 Response[tasks2Data]
+	err = doRequest(c, Task2API, "tasks", request, &response2)
+	if err != nil {
+		return err
+	}
+	response.Success = response2.Success
+	response.Data = TasksData{
+		Offset: response2.Data.Offset,
+		Tasks:  make([]Task, len(response2.Data.Task)),
+		Total:  response2.Data.Total,
+	}
+	for i, t := range response2.Data.Task {
+		response.Data.Tasks[i] = t.task()
+	}
+	return nil
+}
+
+func (c *Client) getTaskV2(ctx context.Context, sid string, id string) (*Task, error) {
+	params := url.Values{
+		"id":         {jsonParam([]string{id})},
+		"additional": {jsonParam(taskInfoAdditional)},
+	}
+	request, err := c.newRequest(ctx, Task2API, "get", sid, params)
+	if err != nil {
+		return nil, fmt.Errorf("creating task info request: %w", err)
+	}
+	var response Response[tasks2Data]
+	err = doRequest(c, Task2API, "task info", request, &response)
+	if err != nil {
+		return nil, err
+	}
+	if len(response.Data.Task) == 0 {
+		return nil, &APIError{API: Task2API, Code: 404}
+	}
+	task := response.Data.Task[0].task()
+	return &task, nil
+}
+
+func (c *Client) createTaskV2(ctx context.Context, sid string, data TaskCreateRequest) (*Response[any], error) {
+	destination := data.Destination
+	var err error
+	if destination == "" {
+		destination, err = c.defaultDestinationV2(ctx, sid)
+		if err != nil {
+			return nil, err
+		}
+	}
+	params := url.Values{
+		"destination": {jsonParam(destination)},
+		"create_list": {"false"},
+	}
+	var request *http.Request
+	if data.File != nil {
+		// the file parameter lists the names of the form parts holding the files
+		params.Set("type", jsonParam("file"))
+		params.Set("file", jsonParam([]string{"torrent"}))
+		upload := FileUpload{Field: "torrent", FileName: data.FileName, Content: data.File}
+		request, err = c.newMultipartRequest(ctx, Task2API, "create", sid, params, upload)
+	} else {
+		params.Set("type", jsonParam("url"))
+		params.Set("url", jsonParam(data.Uris))
+		request, err = c.newRequest(ctx, Task2API, "create", sid, params)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("creating new task request: %w", err)
+	}
+	var response Response[any]
+	err = doRequest(c, Task2API, "new task", request, &response)
+	if err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// defaultDestinationV2 returns the folder set as default destination in the
+// Download Station settings, the DownloadStation2 API requires a destination
+// when creating tasks.
+func (c *Client) defaultDestinationV2(ctx context.Context, sid string) (string, error) {
+	request, err := c.newRequest(ctx, LocationAPI, "get", sid, nil)
+	if err != nil {
+		return "", fmt.Errorf("creating default destination request: %w", err)
+	}
+	var response Response[struct {
+		DefaultDestination string `json:"default_destination"`
+	}]
+	err = doRequest(c, LocationAPI, "default destination", request, &response)
+	if err != nil {
+		return "", err
+	}
+	if response.Data.DefaultDestination == "" {
+		return "", &APIError{API: LocationAPI, Code: 406}
+	}
+	return response.Data.DefaultDestination, nil
+}
+
+func (c *Client) editTaskV2(ctx context.Context, sid string, id string, destination string) error {
+	params := url.Values{
+		"id":          {jsonParam([]string{id})},
+		"destination": {jsonParam(destination)},
+	}
+	request, err := c.newRequest(ctx, Task2API, "edit", sid, params)
+	if err != nil {
+		return fmt.Errorf("creating task edit request: %w", err)
+	}
+	var response Response[task2ChangeData]
+	err = doRequest(c, Task2API, "task edit", request, &response)
+	if err != nil {
+		return err
+	}
+	return response.Data.FailedTask.apiErr(Task2API)
+}
+
+// changeTasksV2 runs one of the delete, pause and resume methods. Only the
+// failed tasks are reported, the others are returned without error.
+func (c *Client) changeTasksV2(ctx context.Context, sid string, method string, ids []string, params url.Values) (TaskChangeData, error) {
+	if params == nil {
+		params = url.Values{}
+	}
+	params.Set("id", jsonParam(ids))
+	request, err := c.newRequest(ctx, Task2API, method, sid, params)
+	if err != nil {
+		return nil, fmt.Errorf("creating tasks request: %w", err)
+	}
+	var response Response[task2ChangeData]
+	err = doRequest(c, Task2API, "task "+method, request, &response)
+	if err != nil {
+		return nil, err
+	}
+	failed := make(map[string]int, len(response.Data.FailedTask))
+	for _, change := range response.Data.FailedTask {
+		failed[change.Id] = change.Error
+	}
+	results := make(TaskChangeData, len(ids))
+	for i, id := range ids {
+		results[i] = TaskChange{Id: id, Error: failed[id]}
+	}
+	return results, results.apiErr(Task2API)
+}
+
+type btFile struct {
+	Index          int    `json:"index"`
+	Name           string `json:"name"`
+	Priority       string `json:"priority"`
+	Size           int64  `json:"size"`
+	SizeDownloaded int64  `json:"size_downloaded"`
+	Wanted         bool   `json:"wanted"`
+}
+
+type btFilesData struct {
+	Items []btFile `json:"items"`
+	Total int      `json:"total"`
+}
+
+// ListTaskFiles returns the files of a BitTorrent task along with their
+// selection and priority.
+func (c *Client) ListTaskFiles(ctx context.Context, sid string, taskId string) ([]TaskFile, error) {
+	params := url.Values{"task_id": {jsonParam(taskId)}}
+	request, err := c.newRequest(ctx, BTFileAPI, "list", sid, params)
+	if err != nil {
+		return nil, fmt.Errorf("creating task files request: %w", err)
+	}
+	var response Response[btFilesData]
+	err = doRequest(c, BTFileAPI, "task files", request, &response)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]TaskFile, len(response.Data.Items))
+	for i, f := range response.Data.Items {
+		files[i] = TaskFile{
+			FileName:       f.Name,
+			Index:          f.Index,
+			Priority:       f.Priority,
+			Size:           f.Size,
+			SizeDownloaded: f.SizeDownloaded,
+			Wanted:         f.Wanted,
+		}
+	}
+	return files, nil
+}
+
+// TaskFilesChange sets whether the files with the given indexes are
+// downloaded and their priority.
+type TaskFilesChange struct {
+	Indexes  []int
+	Wanted   bool
+	Priority string
+}
+
+func (c *Client) SetTaskFiles(ctx context.Context, sid string, taskId string, change TaskFilesChange) error {
+	params := url.Values{
+		"task_id": {jsonParam(taskId)},
+		"index":   {jsonParam(change.Indexes)},
+		"wanted":  {jsonParam(change.Wanted)},
+	}
+	if change.Priority != "" {
+		params.Set("priority", jsonParam(change.Priority))
+	}
+	request, err := c.newRequest(ctx, BTFileAPI, "set", sid, params)
+	if err != nil {
+		return fmt.Errorf("creating task files request: %w", err)
+	}
+	var response Response[any]
+	return doRequest(c, BTFileAPI, "task files set", request, &response)
+}
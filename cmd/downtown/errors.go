@@ -0,0 +1,183 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrSessionExpired matches API errors meaning the sid is no longer
+	// valid and the user has to log in again.
+	ErrSessionExpired = errors.New("session expired")
+	// ErrOTPRequired matches the login error returned when the account has
+	// 2-step verification enabled and no code was provided.
+	ErrOTPRequired = errors.New("2-step verification code required")
+	// ErrOTPInvalid matches the login error returned when the 2-step
+	// verification code was rejected.
+	ErrOTPInvalid = errors.New("invalid 2-step verification code")
+)
+
+// APIError is the error reported by Download Station in the response body
+// of an unsuccessful request.
+type APIError struct {
+	API  string
+	Code int
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s error %d: %s", e.API, e.Code, e.Message())
+}
+
+// Message describes the error code looking it up first in the API specific
+// table and then in the codes common to all the APIs.
+func (e *APIError) Message() string {
+	if message, found := apiErrorMessages[e.API][e.Code]; found {
+		return message
+	}
+	if message, found := commonErrorMessages[e.Code]; found {
+		return message
+	}
+	return "Unknown error"
+}
+
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrSessionExpired:
+		return sessionErrorCodes[e.Code]
+	case ErrOTPRequired:
+		return e.API == AuthAPI && e.Code == 403
+	case ErrOTPInvalid:
+		return e.API == AuthAPI && e.Code == 404
+	}
+	return false
+}
+
+// TaskError is the error reported for one of the tasks changed by a request.
+type TaskError struct {
+	Id  string
+	Err *APIError
+}
+
+func (e *TaskError) Error() string {
+	return fmt.Sprintf("task %s: %s", e.Id, e.Err)
+}
+
+func (e *TaskError) Unwrap() error {
+	return e.Err
+}
+
+// TaskErrors lists the tasks a change failed for, the other tasks of the
+// request were changed.
+type TaskErrors []*TaskError
+
+func (e TaskErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (e TaskErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, err := range e {
+		errs[i] = err
+	}
+	return errs
+}
+
+// sessionErrorCodes are the common error codes meaning the sid has to be
+// renewed by logging in again. DSM also answers 105 when the sid is no longer
+// known, after a reboot of the NAS or a logout from elsewhere.
+var sessionErrorCodes = map[int]bool{
+	105: true,
+	106: true,
+	107: true,
+	119: true,
+}
+
+var commonErrorMessages = map[int]string{
+	100: "Unknown error",
+	101: "Invalid parameter",
+	102: "The requested API does not exist",
+	103: "The requested method does not exist",
+	104: "The requested version does not support the functionality",
+	105: "The logged in session does not have permission",
+	106: "Session timeout",
+	107: "Session interrupted by duplicate login",
+	108: "Failed to upload the file",
+	109: "The network connection is unstable or the system is busy",
+	110: "The network connection is unstable or the system is busy",
+	111: "The network connection is unstable or the system is busy",
+	114: "Lost parameters for this API",
+	115: "Not allowed to upload a file",
+	116: "Not allowed to perform for a demo site",
+	117: "The network connection is unstable or the system is busy",
+	118: "The network connection is unstable or the system is busy",
+	119: "Invalid session",
+}
+
+var apiErrorMessages = map[string]map[int]string{
+	AuthAPI: {
+		400: "No such account or incorrect password",
+		401: "Account disabled",
+		402: "Permission denied",
+		403: "2-step verification code required",
+		404: "Failed to authenticate 2-step verification code",
+		406: "2-step verification must be enabled for this account",
+		407: "Blocked IP source",
+		408: "Expired password cannot be changed",
+		409: "Expired password",
+		410: "Password must be changed",
+	},
+	FileListAPI:   fileStationErrorMessages,
+	FileDeleteAPI: fileStationErrorMessages,
+	TaskAPI:       taskErrorMessages,
+	Task2API:      taskErrorMessages,
+	BTFileAPI:     taskErrorMessages,
+	LocationAPI:   taskErrorMessages,
+}
+
+// taskErrorMessages are the error codes of the Download Station task APIs.
+// The DownloadStation2 APIs of DSM 7 report the same codes.
+var taskErrorMessages = map[int]string{
+	400: "File upload failed",
+	401: "Max number of tasks reached",
+	402: "Destination denied",
+	403: "Destination does not exist",
+	404: "Invalid task id",
+	405: "Invalid task action",
+	406: "No default destination",
+	407: "Set destination failed",
+	408: "File does not exist",
+}
+
+// fileStationErrorMessages are the error codes shared by the File Station
+// APIs.
+var fileStationErrorMessages = map[int]string{
+	400: "Invalid parameter of file operation",
+	401: "Unknown error of file operation",
+	402: "System is too busy",
+	403: "Invalid user does this file operation",
+	404: "Invalid group does this file operation",
+	405: "Invalid user and group does this file operation",
+	406: "Can't get user/group information from the account server",
+	407: "Operation not permitted",
+	408: "No such file or directory",
+	409: "Non-supported file system",
+	410: "Failed to connect internet-based file system",
+	411: "Read-only file system",
+	412: "Filename too long in the non-encrypted file system",
+	413: "Filename too long in the encrypted file system",
+	414: "File already exists",
+	415: "Disk quota exceeded",
+	416: "No space left on device",
+	417: "Input/output error",
+	418: "Illegal name or path",
+	419: "Illegal file name",
+	420: "Illegal file name on FAT file system",
+	421: "Device or resource busy",
+	599: "No such task of the file operation",
+	900: "Failed to delete file(s) or folder(s)",
+}
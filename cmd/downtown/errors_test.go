@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestAPIErrorMessage(t *testing.T) {
+	testCases := []struct {
+		err      *APIError
+		expected string
+	}{
+		{&APIError{API: AuthAPI, Code: 400}, "No such account or incorrect password"},
+		{&APIError{API: TaskAPI, Code: 400}, "File upload failed"},
+		{&APIError{API: TaskAPI, Code: 106}, "Session timeout"},
+		{&APIError{API: TaskAPI, Code: 999}, "Unknown error"},
+		{&APIError{API: Task2API, Code: 404}, "Invalid task id"},
+		{&APIError{API: LocationAPI, Code: 406}, "No default destination"},
+	}
+
+	for _, tc := range testCases {
+		result := tc.err.Message()
+		if result != tc.expected {
+			t.Errorf("%s code %d message is '%s' while '%s' expected", tc.err.API, tc.err.Code, result, tc.expected)
+		}
+	}
+}
+
+func TestAPIErrorIs(t *testing.T) {
+	if !errors.Is(&APIError{API: TaskAPI, Code: 119}, ErrSessionExpired) {
+		t.Error("code 119 expected to be a session expired error")
+	}
+	if !errors.Is(&APIError{API: TaskAPI, Code: 105}, ErrSessionExpired) {
+		t.Error("code 105 expected to be a session expired error")
+	}
+	if !errors.Is(&APIError{API: AuthAPI, Code: 403}, ErrOTPRequired) {
+		t.Error("auth code 403 expected to be an OTP required error")
+	}
+	if errors.Is(&APIError{API: TaskAPI, Code: 403}, ErrOTPRequired) {
+		t.Error("task code 403 not expected to be an OTP required error")
+	}
+}
+
+func TestTaskChangeDataErr(t *testing.T) {
+	if err := (TaskChangeData{{Id: "ID1"}, {Id: "ID2"}}).Err(); err != nil {
+		t.Errorf("no error expected when all tasks changed, got %v", err)
+	}
+
+	err := TaskChangeData{{Id: "ID1"}, {Id: "ID2", Error: 405}, {Id: "ID3", Error: 106}}.Err()
+	var taskErrs TaskErrors
+	if !errors.As(err, &taskErrs) || len(taskErrs) != 2 || taskErrs[0].Id != "ID2" || taskErrs[1].Id != "ID3" {
+		t.Fatalf("ID2 and ID3 errors expected, got %v", err)
+	}
+	if !errors.Is(err, ErrSessionExpired) {
+		t.Error("the session expired error of ID3 expected to be found")
+	}
+	expected := "task ID2: SYNO.DownloadStation.Task error 405: Invalid task action; task ID3: SYNO.DownloadStation.Task error 106: Session timeout"
+	if err.Error() != expected {
+		t.Errorf("error message '%s' while '%s' expected", err.Error(), expected)
+	}
+}
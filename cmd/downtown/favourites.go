@@ -0,0 +1,64 @@
+package main
+
+import (
+	"slices"
+	"sync"
+)
+
+// Favourite is a destination folder saved by a user.
+type Favourite struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+// FavouritesStore keeps the favourite destinations of every NAS user in a
+// JSON file.
+type FavouritesStore struct {
+	mu         sync.Mutex
+	path       string
+	favourites map[string][]Favourite
+}
+
+func NewFavouritesStore(path string) (*FavouritesStore, error) {
+	store := &FavouritesStore{
+		path:       path,
+		favourites: make(map[string][]Favourite),
+	}
+	if err := readDataFile(path, &store.favourites); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func (s *FavouritesStore) List(user string) []Favourite {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.favourites[user])
+}
+
+// Add saves a favourite, replacing the name of an existing one with the
+// same path.
+func (s *FavouritesStore) Add(user string, favourite Favourite) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	favourites := s.favourites[user]
+	i := slices.IndexFunc(favourites, func(f Favourite) bool { return f.Path == favourite.Path })
+	if i >= 0 {
+		favourites[i] = favourite
+	} else {
+		favourites = append(favourites, favourite)
+	}
+	s.favourites[user] = favourites
+	return s.save()
+}
+
+func (s *FavouritesStore) Remove(user string, path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.favourites[user] = slices.DeleteFunc(s.favourites[user], func(f Favourite) bool { return f.Path == path })
+	return s.save()
+}
+
+func (s *FavouritesStore) save() error {
+	return writeDataFile(s.path, s.favourites)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestFavouritesStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", "favourites.json")
+	store, err := NewFavouritesStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if favourites := store.List("user"); len(favourites) != 0 {
+		t.Errorf("favourites %v found in a new store", favourites)
+	}
+
+	for _, favourite := range []Favourite{
+		{Name: "Movies", Path: "video/Movies"},
+		{Name: "ISOs", Path: "downloads/iso"},
+		{Name: "Films", Path: "video/Movies"},
+	} {
+		if err = store.Add("user", favourite); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = store.Remove("user", "downloads/iso"); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := NewFavouritesStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Favourite{{Name: "Films", Path: "video/Movies"}}
+	if favourites := reloaded.List("user"); !slices.Equal(favourites, expected) {
+		t.Errorf("favourites %v loaded while %v expected", favourites, expected)
+	}
+	if favourites := reloaded.List("other"); len(favourites) != 0 {
+		t.Errorf("favourites %v of another user returned", favourites)
+	}
+}
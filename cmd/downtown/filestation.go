@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const (
+	FileListAPI   = "SYNO.FileStation.List"
+	FileDeleteAPI = "SYNO.FileStation.Delete"
+)
+
+// Folder is a shared folder or a directory inside it. Path is the File
+// Station path, starting with a slash and the shared folder name.
+type Folder struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+// Destination is the folder path as expected by the task APIs, without the
+// leading slash.
+func (f Folder) Destination() string {
+	return strings.TrimPrefix(f.Path, "/")
+}
+
+type sharesData struct {
+	Shares []Folder `json:"shares"`
+}
+
+type filesData struct {
+	Files []Folder `json:"files"`
+}
+
+// ListFolders returns the shared folders when path is empty or the root,
+// otherwise the directories inside path.
+func (c *Client) ListFolders(ctx context.Context, sid string, path string) ([]Folder, error) {
+	if path == "" || path == "/" {
+		params := url.Values{"sort_by": {"name"}}
+		request, err := c.newRequest(ctx, FileListAPI, "list_share", sid, params)
+		if err != nil {
+			return nil, fmt.Errorf("creating shared folders request: %w", err)
+		}
+		var response Response[sharesData]
+		err = doRequest(c, FileListAPI, "shared folders", request, &response)
+		if err != nil {
+			return nil, err
+		}
+		return response.Data.Shares, nil
+	}
+
+	params := url.Values{
+		"folder_path": {path},
+		"filetype":    {"dir"},
+		"sort_by":     {"name"},
+	}
+	request, err := c.newRequest(ctx, FileListAPI, "list", sid, params)
+	if err != nil {
+		return nil, fmt.Errorf("creating folders request: %w", err)
+	}
+	var response Response[filesData]
+	err = doRequest(c, FileListAPI, "folders", request, &response)
+	if err != nil {
+		return nil, err
+	}
+	return response.Data.Files, nil
+}
+
+// DeleteFiles removes files and folders, with their content, waiting for the
+// deletion to complete.
+func (c *Client) DeleteFiles(ctx context.Context, sid string, paths []string) error {
+	params := url.Values{
+		"path":      {jsonParam(paths)},
+		"recursive": {"true"},
+	}
+	request, err := c.newRequest(ctx, FileDeleteAPI, "delete", sid, params)
+	if err != nil {
+		return fmt.Errorf("creating delete files request: %w", err)
+	}
+	var response Response[any]
+	return doRequest(c, FileDeleteAPI, "delete files", request, &response)
+}
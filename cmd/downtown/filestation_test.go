@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+const (
+	ExpectedSharesUrl  = "/webapi/entry.cgi?_sid=SID&api=SYNO.FileStation.List&method=list_share&sort_by=name&version=2"
+	ExpectedFoldersUrl = "/webapi/entry.cgi?_sid=SID&api=SYNO.FileStation.List&filetype=dir&folder_path=%2Fvideo&method=list&sort_by=name&version=2"
+)
+
+func TestListShares(t *testing.T) {
+	c, s := testClient(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.RequestURI() != ExpectedSharesUrl {
+			t.Errorf("url '%s' used but expected '%s'", r.URL.RequestURI(), ExpectedSharesUrl)
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"data":{"offset":0,"shares":[{"isdir":true,"name":"video","path":"/video"}],"total":1},"success":true}`))
+	})
+	defer s.Close()
+
+	folders, err := c.ListFolders(context.Background(), "SID", "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(folders) != 1 || folders[0].Name != "video" || folders[0].Destination() != "video" {
+		t.Errorf("shared folders %v returned while video expected", folders)
+	}
+}
+
+func TestListFolders(t *testing.T) {
+	c, s := testClient(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.RequestURI() != ExpectedFoldersUrl {
+			t.Errorf("url '%s' used but expected '%s'", r.URL.RequestURI(), ExpectedFoldersUrl)
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"data":{"files":[{"isdir":true,"name":"Movies","path":"/video/Movies"}],"offset":0,"total":1},"success":true}`))
+	})
+	defer s.Close()
+
+	folders, err := c.ListFolders(context.Background(), "SID", "/video")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(folders) != 1 || folders[0].Destination() != "video/Movies" {
+		t.Errorf("folders %v returned while video/Movies expected", folders)
+	}
+}
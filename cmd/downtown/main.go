@@ -1,9 +1,12 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log/slog"
 	"net/http"
 	"os"
+	"path/filepath"
 	"time"
 )
 
@@ -21,7 +24,25 @@ func main() {
 	}))
 	slog.SetDefault(logger)
 
-	client := NewClient(appConfig.host, logger)
+	client, err := NewClient(appConfig.ClientConfig(), logger)
+	if err != nil {
+		logger.Error("Can't configure the Download Station client", "error", err)
+		os.Exit(1)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	err = client.Discover(ctx)
+	cancel()
+	if errors.Is(err, ErrAPIUnavailable) {
+		logger.Error("Download Station doesn't expose a required API", "error", err)
+		os.Exit(1)
+	} else if err != nil {
+		logger.Warn("Can't discover the Download Station APIs, retrying on first request", "error", err)
+	}
+	if appConfig.scheme == "http" {
+		logger.Warn("Connecting to Download Station over plain HTTP")
+	} else if !appConfig.tlsVerify && appConfig.caFile == "" && appConfig.certFingerprint == "" {
+		logger.Warn("Download Station certificate is not verified")
+	}
 
 	app := App{
 		Config: appConfig,
@@ -29,10 +50,34 @@ func main() {
 		Logger: logger,
 	}
 
+	favourites, err := NewFavouritesStore(filepath.Join(appConfig.dataDir, "favourites.json"))
+	if err != nil {
+		logger.Error("Can't load the favourite destinations", "error", err)
+		os.Exit(1)
+	}
+
+	tokenKey, err := LoadTokenKey(filepath.Join(appConfig.dataDir, "token.key"))
+	if err != nil {
+		logger.Error("Can't load the API token key", "error", err)
+		os.Exit(1)
+	}
+	tokens, err := NewTokenStore(filepath.Join(appConfig.dataDir, "tokens.json"), tokenKey)
+	if err != nil {
+		logger.Error("Can't load the API tokens", "error", err)
+		os.Exit(1)
+	}
+
+	tasksCache := NewTasksCache(client, appConfig.tasksCacheTTL)
+
 	webapp := WebApp{
-		App:       &app,
-		Logger:    logger,
-		Templates: LoadTemplates(),
+		App:        &app,
+		Logger:     logger,
+		Templates:  LoadTemplates(),
+		Sessions:   NewSessionStore([]byte(appConfig.sessionSecret), appConfig.sessionIdleTimeout, appConfig.sessionMaxAge),
+		Favourites: favourites,
+		Tokens:     tokens,
+		TasksCache: tasksCache,
+		TaskFeeds:  NewTaskFeeds(tasksCache, appConfig.tasksPollInterval, logger),
 	}
 	srv := &http.Server{
 		Addr:         appConfig.addr,
@@ -43,8 +88,11 @@ func main() {
 		ErrorLog:     slog.NewLogLogger(logger.Handler(), slog.LevelError),
 	}
 
+	if appConfig.cookieSecure == "true" {
+		logger.Warn("Cookies are secure while the server listens on plain HTTP, browsers drop them unless a HTTPS proxy is in front", "addr", srv.Addr)
+	}
 	logger.Info("Server started", "addr", srv.Addr)
-	err := srv.ListenAndServe()
+	err = srv.ListenAndServe()
 	if err != nil {
 		logger.Error("Shutting down the server", "error", err)
 		os.Exit(1)
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"sync"
+	"time"
+)
+
+const SessionCookieName = "session"
+
+// PendingLoginTimeout is how long the credentials of a login waiting for its
+// 2-step verification code are kept.
+const PendingLoginTimeout = 5 * time.Minute
+
+// Session links a Downtown session to the Download Station sid obtained at
+// login. The sid never leaves the server.
+type Session struct {
+	ID        string
+	User      string
+	SID       string
+	CreatedAt time.Time
+	LastSeen  time.Time
+
+	flash Flash
+}
+
+// Flash is shown once by the page the browser is redirected to after a form
+// is posted, so reloading the page doesn't post the form again.
+type Flash struct {
+	Results  []CreateResult
+	NewToken string
+}
+
+// PendingLogin holds the credentials of a login waiting for its 2-step
+// verification code, so the password doesn't have to go back to the browser.
+type PendingLogin struct {
+	User      string
+	Pass      string
+	CreatedAt time.Time
+}
+
+// SessionStore keeps the sessions in memory. Session ids are random and the
+// cookie carries them signed with an HMAC so forged ids are rejected before
+// touching the map.
+type SessionStore struct {
+	mu          sync.Mutex
+	sessions    map[string]*Session
+	pending     map[string]*PendingLogin
+	secret      []byte
+	idleTimeout time.Duration
+	maxAge      time.Duration
+	now         func() time.Time
+}
+
+func NewSessionStore(secret []byte, idleTimeout, maxAge time.Duration) *SessionStore {
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		_, _ = rand.Read(secret)
+	}
+	return &SessionStore{
+		sessions:    make(map[string]*Session),
+		pending:     make(map[string]*PendingLogin),
+		secret:      secret,
+		idleTimeout: idleTimeout,
+		maxAge:      maxAge,
+		now:         time.Now,
+	}
+}
+
+// Create stores a new session and returns it along with the signed token
+// to send to the browser.
+func (s *SessionStore) Create(user, sid string) (*Session, string) {
+	id := randomToken()
+	now := s.now()
+	session := &Session{
+		ID:        id,
+		User:      user,
+		SID:       sid,
+		CreatedAt: now,
+		LastSeen:  now,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removeExpired(now)
+	s.sessions[id] = session
+	return session, id + "." + s.sign(id)
+}
+
+// Get returns the session for a signed token if it exists and hasn't
+// expired, refreshing its idle timeout.
+func (s *SessionStore) Get(token string) (*Session, bool) {
+	id, ok := s.verify(token)
+	if !ok {
+		return nil, false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, found := s.sessions[id]
+	if !found {
+		return nil, false
+	}
+	now := s.now()
+	if s.expired(session, now) {
+		delete(s.sessions, id)
+		return nil, false
+	}
+	session.LastSeen = now
+	return session, true
+}
+
+// Active tells whether the session of a signed token still exists and
+// hasn't expired, without refreshing its idle timeout.
+func (s *SessionStore) Active(token string) bool {
+	id, ok := s.verify(token)
+	if !ok {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, found := s.sessions[id]
+	return found && !s.expired(session, s.now())
+}
+
+func (s *SessionStore) Delete(token string) {
+	id, ok := s.verify(token)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, id)
+}
+
+// CreatePending keeps the credentials of a login until the verification code
+// is entered and returns the random token the login form posts back.
+func (s *SessionStore) CreatePending(user, pass string) string {
+	token := randomToken()
+	now := s.now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removeExpired(now)
+	s.pending[token] = &PendingLogin{User: user, Pass: pass, CreatedAt: now}
+	return token
+}
+
+// Pending returns the credentials of a login waiting for its verification
+// code. They're kept until deleted or expired so a wrong code can be retried.
+func (s *SessionStore) Pending(token string) (PendingLogin, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	login, found := s.pending[token]
+	if !found || s.now().Sub(login.CreatedAt) > PendingLoginTimeout {
+		delete(s.pending, token)
+		return PendingLogin{}, false
+	}
+	return *login, true
+}
+
+func (s *SessionStore) DeletePending(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.pending, token)
+}
+
+// SetFlash keeps the flash of the session until it's taken.
+func (s *SessionStore) SetFlash(session *Session, flash Flash) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session.flash = flash
+}
+
+// TakeFlash returns the flash of the session and forgets it.
+func (s *SessionStore) TakeFlash(session *Session) Flash {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	flash := session.flash
+	session.flash = Flash{}
+	return flash
+}
+
+func (s *SessionStore) expired(session *Session, now time.Time) bool {
+	return now.Sub(session.LastSeen) > s.idleTimeout || now.Sub(session.CreatedAt) > s.maxAge
+}
+
+func (s *SessionStore) removeExpired(now time.Time) {
+	for id, session := range s.sessions {
+		if s.expired(session, now) {
+			delete(s.sessions, id)
+		}
+	}
+	for token, login := range s.pending {
+		if now.Sub(login.CreatedAt) > PendingLoginTimeout {
+			delete(s.pending, token)
+		}
+	}
+}
+
+func (s *SessionStore) sign(id string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(id))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (s *SessionStore) verify(token string) (string, bool) {
+	id, signature, found := strings.Cut(token, ".")
+	if !found || !hmac.Equal([]byte(signature), []byte(s.sign(id))) {
+		return "", false
+	}
+	return id, true
+}
+
+func randomToken() string {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSessionStore(t *testing.T) {
+	store := NewSessionStore([]byte("secret"), time.Hour, 24*time.Hour)
+	created, token := store.Create("user", "SID")
+
+	session, found := store.Get(token)
+	if !found {
+		t.Fatal("session not found")
+	}
+	if session.ID != created.ID || session.SID != "SID" {
+		t.Errorf("session %+v returned while %+v expected", session, created)
+	}
+
+	store.Delete(token)
+	if _, found = store.Get(token); found {
+		t.Error("session found after delete")
+	}
+}
+
+func TestSessionStoreRejectsForgedToken(t *testing.T) {
+	store := NewSessionStore([]byte("secret"), time.Hour, 24*time.Hour)
+	created, _ := store.Create("user", "SID")
+
+	testCases := []string{"", created.ID, created.ID + ".", created.ID + ".forged"}
+	for _, token := range testCases {
+		if _, found := store.Get(token); found {
+			t.Errorf("session found with forged token '%s'", token)
+		}
+	}
+}
+
+func TestSessionStoreTimeouts(t *testing.T) {
+	now := time.Now()
+	store := NewSessionStore([]byte("secret"), time.Hour, 3*time.Hour)
+	store.now = func() time.Time { return now }
+	_, token := store.Create("user", "SID")
+
+	now = now.Add(59 * time.Minute)
+	if _, found := store.Get(token); !found {
+		t.Fatal("session expired before idle timeout")
+	}
+	now = now.Add(59 * time.Minute)
+	if _, found := store.Get(token); !found {
+		t.Fatal("idle timeout not refreshed by Get")
+	}
+	now = now.Add(2 * time.Hour)
+	if _, found := store.Get(token); found {
+		t.Error("session still valid after idle timeout")
+	}
+
+	_, token = store.Create("user", "SID")
+	for range 4 {
+		now = now.Add(50 * time.Minute)
+		store.Get(token)
+	}
+	if _, found := store.Get(token); found {
+		t.Error("session still valid after max age")
+	}
+}
+
+func TestSessionStoreActive(t *testing.T) {
+	now := time.Now()
+	store := NewSessionStore([]byte("secret"), time.Hour, 3*time.Hour)
+	store.now = func() time.Time { return now }
+	_, token := store.Create("user", "SID")
+
+	now = now.Add(59 * time.Minute)
+	if !store.Active(token) {
+		t.Fatal("session expected active before idle timeout")
+	}
+	now = now.Add(2 * time.Minute)
+	if store.Active(token) {
+		t.Error("idle timeout refreshed by Active")
+	}
+	if store.Active("forged." + token) {
+		t.Error("forged token reported active")
+	}
+}
+
+func TestSessionStorePendingLogin(t *testing.T) {
+	now := time.Now()
+	store := NewSessionStore([]byte("secret"), time.Hour, 3*time.Hour)
+	store.now = func() time.Time { return now }
+	token := store.CreatePending("user", "pass")
+
+	login, found := store.Pending(token)
+	if !found || login.User != "user" || login.Pass != "pass" {
+		t.Fatalf("pending login %+v found %v", login, found)
+	}
+	now = now.Add(PendingLoginTimeout + time.Second)
+	if _, found = store.Pending(token); found {
+		t.Error("pending login still valid after its timeout")
+	}
+
+	token = store.CreatePending("user", "pass")
+	store.DeletePending(token)
+	if _, found = store.Pending(token); found {
+		t.Error("pending login found after delete")
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// TaskFeeds polls the tasks of every NAS account with open task pages, one
+// poller per account and page of the list shared by all its browser tabs.
+// Subscribers are told when the tasks change and read the latest snapshot. A
+// poller without subscribers backs off and stops once its interval reaches
+// maxInterval.
+type TaskFeeds struct {
+	mu          sync.Mutex
+	feeds       map[tasksKey]*taskFeed
+	cache       *TasksCache
+	logger      *slog.Logger
+	interval    time.Duration
+	maxInterval time.Duration
+}
+
+type taskFeed struct {
+	key         tasksKey
+	sid         string
+	tasks       []Task
+	polled      bool
+	subscribers map[*TaskSubscription]struct{}
+	wake        chan struct{}
+}
+
+// TaskSubscription receives a signal on Updates every time the tasks of the
+// account change or its session expires.
+type TaskSubscription struct {
+	Updates chan struct{}
+	feeds   *TaskFeeds
+	feed    *taskFeed
+	sid     string
+	expired bool
+}
+
+func NewTaskFeeds(cache *TasksCache, interval time.Duration, logger *slog.Logger) *TaskFeeds {
+	return &TaskFeeds{
+		feeds:       make(map[tasksKey]*taskFeed),
+		cache:       cache,
+		logger:      logger,
+		interval:    interval,
+		maxInterval: time.Minute,
+	}
+}
+
+// Subscribe follows a page of the tasks of the user, polling them with the
+// sid of the latest subscriber. Unsubscribe must be called when done.
+func (f *TaskFeeds) Subscribe(user, sid string, page TasksPage) *TaskSubscription {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := tasksKey{user: user, page: page}
+	feed, found := f.feeds[key]
+	if !found {
+		feed = &taskFeed{
+			key:         key,
+			subscribers: make(map[*TaskSubscription]struct{}),
+			wake:        make(chan struct{}, 1),
+		}
+		f.feeds[key] = feed
+		go f.run(feed)
+	} else {
+		notify(feed.wake)
+	}
+	subscription := &TaskSubscription{
+		Updates: make(chan struct{}, 1),
+		feeds:   f,
+		feed:    feed,
+		sid:     sid,
+	}
+	feed.subscribers[subscription] = struct{}{}
+	feed.sid = sid
+	if feed.polled {
+		notify(subscription.Updates)
+	}
+	return subscription
+}
+
+func (s *TaskSubscription) Unsubscribe() {
+	s.feeds.mu.Lock()
+	defer s.feeds.mu.Unlock()
+	delete(s.feed.subscribers, s)
+}
+
+// Tasks returns the latest tasks of the account, ErrSessionExpired once the
+// sid of the subscriber is no longer valid.
+func (s *TaskSubscription) Tasks() ([]Task, error) {
+	s.feeds.mu.Lock()
+	defer s.feeds.mu.Unlock()
+	if s.expired {
+		return nil, ErrSessionExpired
+	}
+	return s.feed.tasks, nil
+}
+
+func (f *TaskFeeds) run(feed *taskFeed) {
+	interval := f.interval
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+		case <-feed.wake:
+			interval = f.interval
+		}
+
+		f.mu.Lock()
+		sid := feed.sid
+		f.mu.Unlock()
+		var tasks []Task
+		var err error
+		if sid != "" {
+			tasks, err = f.poll(feed.key, sid)
+		}
+
+		f.mu.Lock()
+		switch {
+		case sid == "":
+		case errors.Is(err, ErrSessionExpired):
+			f.expire(feed, sid)
+		case err != nil:
+			f.logger.Warn("polling tasks error", "user", feed.key.user, "error", err)
+		case !feed.polled || !reflect.DeepEqual(tasks, feed.tasks):
+			feed.tasks, feed.polled = tasks, true
+			for subscription := range feed.subscribers {
+				notify(subscription.Updates)
+			}
+		}
+		if err != nil || len(feed.subscribers) == 0 {
+			interval = min(interval*2, f.maxInterval)
+		} else {
+			interval = f.interval
+		}
+		if len(feed.subscribers) == 0 && interval == f.maxInterval {
+			delete(f.feeds, feed.key)
+			f.mu.Unlock()
+			return
+		}
+		f.mu.Unlock()
+		timer.Reset(interval)
+	}
+}
+
+func (f *TaskFeeds) poll(key tasksKey, sid string) ([]Task, error) {
+	data, err := f.cache.GetPage(context.Background(), key.user, sid, key.page)
+	return data.Tasks, err
+}
+
+// Refresh polls the tasks of the user right away, if someone follows them.
+func (f *TaskFeeds) Refresh(user string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for key, feed := range f.feeds {
+		if key.user == user {
+			notify(feed.wake)
+		}
+	}
+}
+
+// expire tells the subscribers using the sid that it expired and switches
+// the feed to the sid of another subscriber, if any.
+func (f *TaskFeeds) expire(feed *taskFeed, sid string) {
+	feed.sid = ""
+	for subscription := range feed.subscribers {
+		if subscription.sid == sid {
+			subscription.expired = true
+			notify(subscription.Updates)
+		} else if !subscription.expired {
+			feed.sid = subscription.sid
+		}
+	}
+}
+
+// notify signals a channel without blocking, pending signals are coalesced.
+func notify(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
+
+// diffTasks compares the tasks sent to a client with the latest ones.
+func diffTasks(previous, tasks []Task) (added []Task, changed []Task, removed []string) {
+	previousById := make(map[string]Task, len(previous))
+	for _, task := range previous {
+		previousById[task.Id] = task
+	}
+	for _, task := range tasks {
+		previousTask, found := previousById[task.Id]
+		if !found {
+			added = append(added, task)
+		} else if !reflect.DeepEqual(task, previousTask) {
+			changed = append(changed, task)
+		}
+		delete(previousById, task.Id)
+	}
+	for _, task := range previous {
+		if _, found := previousById[task.Id]; found {
+			removed = append(removed, task.Id)
+		}
+	}
+	return added, changed, removed
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDiffTasks(t *testing.T) {
+	previous := []Task{
+		{Id: "ID1", Status: "downloading"},
+		{Id: "ID2", Status: "downloading"},
+		{Id: "ID3", Status: "paused"},
+	}
+	tasks := []Task{
+		{Id: "ID1", Status: "downloading"},
+		{Id: "ID2", Status: "finished"},
+		{Id: "ID4", Status: "waiting"},
+	}
+	added, changed, removed := diffTasks(previous, tasks)
+	if len(added) != 1 || added[0].Id != "ID4" {
+		t.Errorf("added %v while ID4 expected", added)
+	}
+	if len(changed) != 1 || changed[0].Id != "ID2" {
+		t.Errorf("changed %v while ID2 expected", changed)
+	}
+	if !slices.Equal(removed, []string{"ID3"}) {
+		t.Errorf("removed %v while ID3 expected", removed)
+	}
+}
+
+func waitUpdate(t *testing.T, subscription *TaskSubscription) {
+	t.Helper()
+	select {
+	case <-subscription.Updates:
+	case <-time.After(time.Second):
+		t.Fatal("no update received")
+	}
+}
+
+func TestTaskFeedsSharePoller(t *testing.T) {
+	var polls atomic.Int32
+	status := atomic.Value{}
+	status.Store("downloading")
+	c, s := testClient(func(w http.ResponseWriter, r *http.Request) {
+		polls.Add(1)
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"data":{"offset":0,"tasks":[{"id":"ID1","status":"` + status.Load().(string) + `"}],"total":1},"success":true}`))
+	})
+	defer s.Close()
+	feeds := NewTaskFeeds(NewTasksCache(c, 0), 10*time.Millisecond, slog.Default())
+	feeds.maxInterval = 40 * time.Millisecond
+
+	first := feeds.Subscribe("user", "SID1", TasksPage{})
+	second := feeds.Subscribe("user", "SID2", TasksPage{})
+	waitUpdate(t, first)
+	waitUpdate(t, second)
+	if tasks, err := second.Tasks(); err != nil || len(tasks) != 1 || tasks[0].Status != "downloading" {
+		t.Fatalf("downloading task expected, got %v %v", tasks, err)
+	}
+
+	status.Store("finished")
+	waitUpdate(t, first)
+	if tasks, _ := first.Tasks(); tasks[0].Status != "finished" {
+		t.Errorf("finished task expected, got %v", tasks)
+	}
+
+	first.Unsubscribe()
+	second.Unsubscribe()
+	time.Sleep(200 * time.Millisecond)
+	feeds.mu.Lock()
+	running := len(feeds.feeds)
+	feeds.mu.Unlock()
+	if running != 0 {
+		t.Error("poller still running without subscribers")
+	}
+	stopped := polls.Load()
+	time.Sleep(50 * time.Millisecond)
+	if polls.Load() != stopped {
+		t.Error("tasks polled after the poller stopped")
+	}
+}
+
+func TestTaskFeedsSessionExpired(t *testing.T) {
+	c, s := testClient(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		if r.FormValue("_sid") == "EXPIRED" {
+			_, _ = w.Write([]byte(`{"error":{"code":106},"success":false}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":{"offset":0,"tasks":[],"total":0},"success":true}`))
+	})
+	defer s.Close()
+	feeds := NewTaskFeeds(NewTasksCache(c, 0), 10*time.Millisecond, slog.Default())
+
+	valid := feeds.Subscribe("user", "SID", TasksPage{})
+	defer valid.Unsubscribe()
+	waitUpdate(t, valid)
+	expired := feeds.Subscribe("user", "EXPIRED", TasksPage{})
+	defer expired.Unsubscribe()
+	// the update of the subscription and the one of the expiry may be coalesced
+	var err error
+	for range 2 {
+		waitUpdate(t, expired)
+		if _, err = expired.Tasks(); err != nil {
+			break
+		}
+	}
+	if !errors.Is(err, ErrSessionExpired) {
+		t.Errorf("session expired expected, got %v", err)
+	}
+	if _, err := valid.Tasks(); err != nil {
+		t.Errorf("tasks expected for the valid session, got %v", err)
+	}
+}
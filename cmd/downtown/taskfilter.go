@@ -0,0 +1,144 @@
+package main
+
+import (
+	"cmp"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+var (
+	TaskStatusFilters = []string{"downloading", "seeding", "paused", "finished", "error"}
+	TaskTypeFilters   = []string{"bt", "http", "ftp", "nzb"}
+	TaskSorts         = []string{"name", "size", "progress", "speed", "status"}
+)
+
+// TaskFilter selects and orders the tasks shown in the tasks list. Empty
+// fields don't filter, an empty Sort keeps the Download Station order.
+type TaskFilter struct {
+	Status string
+	Type   string
+	Owner  string
+	Search string
+	Sort   string
+	Desc   bool
+}
+
+var taskFilterParams = []string{"status", "type", "owner", "q", "sort", "order"}
+
+// HasTaskFilter tells whether the values carry a filter, even an empty one.
+func HasTaskFilter(values url.Values) bool {
+	return slices.ContainsFunc(taskFilterParams, values.Has)
+}
+
+// ParseTaskFilter reads a filter from query values, ignoring the values it
+// doesn't know.
+func ParseTaskFilter(values url.Values) TaskFilter {
+	filter := TaskFilter{
+		Owner:  strings.TrimSpace(values.Get("owner")),
+		Search: strings.TrimSpace(values.Get("q")),
+		Desc:   values.Get("order") == "desc",
+	}
+	if status := values.Get("status"); slices.Contains(TaskStatusFilters, status) {
+		filter.Status = status
+	}
+	if taskType := values.Get("type"); slices.Contains(TaskTypeFilters, taskType) {
+		filter.Type = taskType
+	}
+	if sort := values.Get("sort"); slices.Contains(TaskSorts, sort) {
+		filter.Sort = sort
+	}
+	return filter
+}
+
+// Values are the query values of the filter, without the empty ones.
+func (f TaskFilter) Values() url.Values {
+	values := url.Values{}
+	for param, value := range map[string]string{
+		"status": f.Status,
+		"type":   f.Type,
+		"owner":  f.Owner,
+		"q":      f.Search,
+		"sort":   f.Sort,
+	} {
+		if value != "" {
+			values.Set(param, value)
+		}
+	}
+	if f.Desc {
+		values.Set("order", "desc")
+	}
+	return values
+}
+
+func (f TaskFilter) IsZero() bool {
+	return f == TaskFilter{}
+}
+
+// Apply returns the tasks matching the filter in its order. The tasks are
+// copied, the ones passed may be shared.
+func (f TaskFilter) Apply(tasks []Task) []Task {
+	search := strings.ToLower(f.Search)
+	filtered := make([]Task, 0, len(tasks))
+	for _, task := range tasks {
+		if (f.Status == "" || task.Status == f.Status) &&
+			(f.Type == "" || task.Type == f.Type) &&
+			(f.Owner == "" || task.Username == f.Owner) &&
+			(search == "" || strings.Contains(strings.ToLower(task.Title), search)) {
+			filtered = append(filtered, task)
+		}
+	}
+	compare, found := taskComparisons[f.Sort]
+	if !found {
+		return filtered
+	}
+	slices.SortStableFunc(filtered, func(a, b Task) int {
+		c := cmp.Or(compare(a, b), strings.Compare(strings.ToLower(a.Title), strings.ToLower(b.Title)))
+		if f.Desc {
+			return -c
+		}
+		return c
+	})
+	return filtered
+}
+
+var taskComparisons = map[string]func(a, b Task) int{
+	"name": func(a, b Task) int {
+		return strings.Compare(strings.ToLower(a.Title), strings.ToLower(b.Title))
+	},
+	"size": func(a, b Task) int {
+		return cmp.Compare(a.Size, b.Size)
+	},
+	"progress": func(a, b Task) int {
+		return cmp.Compare(taskProgress(a), taskProgress(b))
+	},
+	"speed": func(a, b Task) int {
+		return cmp.Compare(taskSpeed(a), taskSpeed(b))
+	},
+	"status": func(a, b Task) int {
+		return strings.Compare(a.Status, b.Status)
+	},
+}
+
+func taskProgress(task Task) float64 {
+	if task.Size == 0 {
+		return 0
+	}
+	return float64(task.Additional.Transfer.SizeDownloaded) / float64(task.Size)
+}
+
+func taskSpeed(task Task) int64 {
+	return task.Additional.Transfer.SpeedDownload + task.Additional.Transfer.SpeedUpload
+}
+
+// taskOwners lists the owners of the tasks, sorted.
+func taskOwners(tasks []Task) []string {
+	var owners []string
+	for _, task := range tasks {
+		if task.Username != "" && !slices.Contains(owners, task.Username) {
+			owners = append(owners, task.Username)
+		}
+	}
+	slices.Sort(owners)
+	return owners
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/url"
+	"slices"
+	"testing"
+)
+
+func taskIds(tasks []Task) []string {
+	var ids []string
+	for _, task := range tasks {
+		ids = append(ids, task.Id)
+	}
+	return ids
+}
+
+func TestParseTaskFilter(t *testing.T) {
+	values, _ := url.ParseQuery("status=paused&type=gopher&owner=+alice+&q=+ubuntu&sort=speed&order=desc")
+	filter := ParseTaskFilter(values)
+	expected := TaskFilter{Status: "paused", Owner: "alice", Search: "ubuntu", Sort: "speed", Desc: true}
+	if filter != expected {
+		t.Errorf("filter %+v parsed while %+v expected", filter, expected)
+	}
+	if reparsed := ParseTaskFilter(filter.Values()); reparsed != filter {
+		t.Errorf("filter %+v doesn't survive its query values, got %+v", filter, reparsed)
+	}
+	if !HasTaskFilter(url.Values{"q": {""}}) || HasTaskFilter(url.Values{"page": {"2"}}) {
+		t.Error("filter presence not detected from the query keys")
+	}
+}
+
+func TestTaskFilterApply(t *testing.T) {
+	tasks := []Task{
+		{Id: "ID1", Title: "Ubuntu.iso", Status: "downloading", Type: "bt", Username: "alice", Size: 100,
+			Additional: TaskAdditional{Transfer: TaskTransfer{SizeDownloaded: 50, SpeedDownload: 10}}},
+		{Id: "ID2", Title: "debian.iso", Status: "paused", Type: "http", Username: "bob", Size: 300},
+		{Id: "ID3", Title: "ubuntu-server.iso", Status: "downloading", Type: "bt", Username: "bob", Size: 200,
+			Additional: TaskAdditional{Transfer: TaskTransfer{SizeDownloaded: 180, SpeedDownload: 30}}},
+	}
+	testCases := []struct {
+		name     string
+		filter   TaskFilter
+		expected []string
+	}{
+		{"no filter", TaskFilter{}, []string{"ID1", "ID2", "ID3"}},
+		{"status", TaskFilter{Status: "downloading"}, []string{"ID1", "ID3"}},
+		{"type", TaskFilter{Type: "http"}, []string{"ID2"}},
+		{"owner", TaskFilter{Owner: "bob"}, []string{"ID2", "ID3"}},
+		{"search ignores case", TaskFilter{Search: "UBUNTU"}, []string{"ID1", "ID3"}},
+		{"name", TaskFilter{Sort: "name"}, []string{"ID2", "ID3", "ID1"}},
+		{"size descending", TaskFilter{Sort: "size", Desc: true}, []string{"ID2", "ID3", "ID1"}},
+		{"progress", TaskFilter{Sort: "progress"}, []string{"ID2", "ID1", "ID3"}},
+		{"speed of bob descending", TaskFilter{Owner: "bob", Sort: "speed", Desc: true}, []string{"ID3", "ID2"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if ids := taskIds(tc.filter.Apply(tasks)); !slices.Equal(ids, tc.expected) {
+				t.Errorf("tasks %v while %v expected", ids, tc.expected)
+			}
+		})
+	}
+	if ids := taskIds(tasks); !slices.Equal(ids, []string{"ID1", "ID2", "ID3"}) {
+		t.Errorf("tasks passed to the filter were reordered: %v", ids)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// TasksCache keeps the tasks lists of every NAS user for a short time so the
+// open pages, the live updates and the API of the same account share the
+// Download Station calls. The whole list and the pages of the web list are
+// cached on their own, the other pages always call Download Station.
+// Concurrent requests missing the cache wait for a single call. The lists of
+// a user are invalidated when Downtown changes one of its tasks, the expired
+// ones are dropped when the cache is read.
+type TasksCache struct {
+	mu      sync.Mutex
+	entries map[tasksKey]*tasksEntry
+	client  *Client
+	ttl     time.Duration
+	now     func() time.Time
+}
+
+type tasksKey struct {
+	user string
+	page TasksPage
+}
+
+type tasksEntry struct {
+	data      TasksData
+	fetchedAt time.Time
+	fetched   bool
+	call      *tasksCall
+}
+
+// tasksCall is a Download Station call shared by the requests waiting for
+// it.
+type tasksCall struct {
+	done chan struct{}
+	sid  string
+	data TasksData
+	err  error
+}
+
+func NewTasksCache(client *Client, ttl time.Duration) *TasksCache {
+	return &TasksCache{
+		entries: make(map[tasksKey]*tasksEntry),
+		client:  client,
+		ttl:     ttl,
+		now:     time.Now,
+	}
+}
+
+// Get returns all the tasks of the user, calling Download Station with the
+// sid when the cached list is missing or too old. The returned tasks are
+// shared and must not be modified.
+func (c *TasksCache) Get(ctx context.Context, user, sid string) (TasksData, error) {
+	return c.GetPage(ctx, user, sid, TasksPage{})
+}
+
+// GetPage is like Get for a page of the tasks.
+func (c *TasksCache) GetPage(ctx context.Context, user, sid string, page TasksPage) (TasksData, error) {
+	if !cachedPage(page) {
+		var tasksResponse Response[TasksData]
+		err := c.client.GetTasksPage(ctx, sid, page, &tasksResponse)
+		return tasksResponse.Data, err
+	}
+	key := tasksKey{user: user, page: page}
+	c.mu.Lock()
+	c.removeExpired()
+	entry, found := c.entries[key]
+	if !found {
+		entry = &tasksEntry{}
+		c.entries[key] = entry
+	}
+	if entry.fetched && c.now().Sub(entry.fetchedAt) < c.ttl {
+		data := entry.data
+		c.mu.Unlock()
+		return data, nil
+	}
+	call := entry.call
+	if call == nil {
+		call = &tasksCall{done: make(chan struct{}), sid: sid}
+		entry.call = call
+		go c.fetch(key, entry, call)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return TasksData{}, ctx.Err()
+	case <-call.done:
+	}
+	// the sid of another session of the user expired, not necessarily this one
+	if errors.Is(call.err, ErrSessionExpired) && call.sid != sid {
+		return c.GetPage(ctx, user, sid, page)
+	}
+	return call.data, call.err
+}
+
+// cachedPage tells whether the page is kept in the cache: the whole list
+// and the pages of the web list. Any other offset and limit would add an
+// entry for every request.
+func cachedPage(page TasksPage) bool {
+	if page == (TasksPage{}) {
+		return true
+	}
+	return page.Limit == TasksPageSize && page.Offset%TasksPageSize == 0
+}
+
+// removeExpired drops the lists that are too old and aren't being fetched.
+// The caller holds the lock.
+func (c *TasksCache) removeExpired() {
+	now := c.now()
+	for key, entry := range c.entries {
+		if entry.call == nil && (!entry.fetched || now.Sub(entry.fetchedAt) >= c.ttl) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// fetch runs the call detached from the request that started it, the other
+// requests waiting for it would fail too if it was canceled.
+func (c *TasksCache) fetch(key tasksKey, entry *tasksEntry, call *tasksCall) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	var tasksResponse Response[TasksData]
+	call.err = c.client.GetTasksPage(ctx, call.sid, key.page, &tasksResponse)
+	call.data = tasksResponse.Data
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry.call = nil
+	if call.err == nil && c.entries[key] == entry {
+		entry.data, entry.fetchedAt, entry.fetched = call.data, c.now(), true
+	}
+	close(call.done)
+}
+
+// Invalidate forgets all the pages of the tasks of the user. A call already
+// running isn't cached when it completes.
+func (c *TasksCache) Invalidate(user string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.entries {
+		if key.user == user {
+			delete(c.entries, key)
+		}
+	}
+}
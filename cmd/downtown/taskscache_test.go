@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testTasksCache(ttl time.Duration, f http.HandlerFunc) (*TasksCache, *atomic.Int32, func()) {
+	var calls atomic.Int32
+	c, s := testClient(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		f(w, r)
+	})
+	return NewTasksCache(c, ttl), &calls, s.Close
+}
+
+func writeTasks(w http.ResponseWriter, _ *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(`{"data":{"offset":0,"tasks":[{"id":"ID1","status":"downloading"}],"total":1},"success":true}`))
+}
+
+func TestTasksCacheTTL(t *testing.T) {
+	cache, calls, closeServer := testTasksCache(time.Minute, writeTasks)
+	defer closeServer()
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	for range 2 {
+		data, err := cache.Get(context.Background(), "user", "SID")
+		if err != nil || data.Total != 1 {
+			t.Fatalf("tasks expected, got %+v %v", data, err)
+		}
+	}
+	if calls.Load() != 1 {
+		t.Errorf("1 call expected within the TTL, got %d", calls.Load())
+	}
+
+	now = now.Add(time.Minute)
+	_, _ = cache.Get(context.Background(), "user", "SID")
+	if calls.Load() != 2 {
+		t.Errorf("2 calls expected after the TTL, got %d", calls.Load())
+	}
+	_, _ = cache.Get(context.Background(), "other", "OTHERSID")
+	if calls.Load() != 3 {
+		t.Errorf("tasks of another user expected from Download Station, got %d calls", calls.Load())
+	}
+}
+
+func TestTasksCacheCoalescing(t *testing.T) {
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	cache, calls, closeServer := testTasksCache(time.Minute, func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+		writeTasks(w, r)
+	})
+	defer closeServer()
+
+	var wg sync.WaitGroup
+	for i := range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := cache.Get(context.Background(), "user", "SID"); err != nil {
+				t.Error(err)
+			}
+		}()
+		if i == 0 {
+			<-entered
+		}
+	}
+	close(release)
+	wg.Wait()
+	if calls.Load() != 1 {
+		t.Errorf("1 call expected for concurrent requests, got %d", calls.Load())
+	}
+}
+
+func TestTasksCacheInvalidate(t *testing.T) {
+	cache, calls, closeServer := testTasksCache(time.Minute, writeTasks)
+	defer closeServer()
+
+	_, _ = cache.Get(context.Background(), "user", "SID")
+	cache.Invalidate("user")
+	_, _ = cache.Get(context.Background(), "user", "SID")
+	if calls.Load() != 2 {
+		t.Errorf("2 calls expected after invalidating, got %d", calls.Load())
+	}
+}
+
+func TestTasksCacheSessionExpired(t *testing.T) {
+	cache, _, closeServer := testTasksCache(time.Minute, func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("_sid") == "EXPIRED" {
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte(`{"error":{"code":106},"success":false}`))
+			return
+		}
+		writeTasks(w, r)
+	})
+	defer closeServer()
+
+	if _, err := cache.Get(context.Background(), "user", "EXPIRED"); !errors.Is(err, ErrSessionExpired) {
+		t.Errorf("session expired expected, got %v", err)
+	}
+	if _, err := cache.Get(context.Background(), "user", "SID"); err != nil {
+		t.Errorf("tasks expected for another session of the user, got %v", err)
+	}
+}
+
+func TestTasksCachePages(t *testing.T) {
+	cache, calls, closeServer := testTasksCache(time.Minute, writeTasks)
+	defer closeServer()
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	for offset := range 10 {
+		_, _ = cache.GetPage(context.Background(), "user", "SID", TasksPage{Offset: offset, Limit: 7})
+	}
+	if calls.Load() != 10 || len(cache.entries) != 0 {
+		t.Errorf("pages outside of the web list expected from Download Station and not cached, got %d calls and %d entries", calls.Load(), len(cache.entries))
+	}
+
+	for page := range 10 {
+		_, _ = cache.GetPage(context.Background(), "user", "SID", TasksPage{Offset: page * TasksPageSize, Limit: TasksPageSize})
+	}
+	if len(cache.entries) != 10 {
+		t.Errorf("10 pages of the web list expected in the cache, got %d", len(cache.entries))
+	}
+	now = now.Add(time.Minute)
+	_, _ = cache.Get(context.Background(), "user", "SID")
+	if len(cache.entries) != 1 {
+		t.Errorf("expired pages expected to be removed, %d entries left", len(cache.entries))
+	}
+}
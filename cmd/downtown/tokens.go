@@ -0,0 +1,237 @@
+package main
+
+import (
+	"cmp"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// APITokenPrefix starts every personal API token, telling them apart from
+// session tokens.
+const APITokenPrefix = "dtk_"
+
+// ErrInvalidToken is returned when an API token is unknown, revoked or
+// doesn't match the stored hash.
+var ErrInvalidToken = errors.New("invalid API token")
+
+// APIToken is a long-lived token a user created for the JSON API. Only the
+// hash of the token is kept, the NAS credentials are encrypted with a key
+// derived from the token itself so they can't be read back without it.
+type APIToken struct {
+	ID          string    `json:"id"`
+	User        string    `json:"user"`
+	Name        string    `json:"name"`
+	Hash        string    `json:"hash"`
+	Credentials []byte    `json:"credentials"`
+	CreatedAt   time.Time `json:"created_at"`
+	LastUsed    time.Time `json:"last_used,omitzero"`
+}
+
+// NASCredentials are used to log in to DSM on behalf of the token holder.
+// DeviceId is the device token trusted after a 2-step verification.
+type NASCredentials struct {
+	User     string `json:"user"`
+	Pass     string `json:"pass"`
+	DeviceId string `json:"device_id,omitempty"`
+}
+
+// TokenStore keeps the API tokens of every NAS user in a JSON file.
+type TokenStore struct {
+	mu     sync.Mutex
+	path   string
+	key    []byte
+	tokens map[string]*APIToken
+	now    func() time.Time
+}
+
+func NewTokenStore(path string, key []byte) (*TokenStore, error) {
+	store := &TokenStore{
+		path:   path,
+		key:    key,
+		tokens: make(map[string]*APIToken),
+		now:    time.Now,
+	}
+	if err := readDataFile(path, &store.tokens); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// LoadTokenKey reads the key encrypting the NAS credentials of the tokens,
+// generating it the first time.
+func LoadTokenKey(path string) ([]byte, error) {
+	key, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		key = make([]byte, 32)
+		_, _ = rand.Read(key)
+		if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return nil, fmt.Errorf("creating data directory: %w", err)
+		}
+		if err = os.WriteFile(path, key, 0600); err != nil {
+			return nil, fmt.Errorf("writing token key: %w", err)
+		}
+		return key, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading token key: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("token key %s is not 32 bytes long", path)
+	}
+	return key, nil
+}
+
+// List returns the tokens of a user, oldest first.
+func (s *TokenStore) List(user string) []APIToken {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var tokens []APIToken
+	for _, token := range s.tokens {
+		if token.User == user {
+			tokens = append(tokens, *token)
+		}
+	}
+	slices.SortFunc(tokens, func(a, b APIToken) int {
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), strings.Compare(a.ID, b.ID))
+	})
+	return tokens
+}
+
+// Create stores a new token for the credentials and returns it. The token
+// can't be recovered later.
+func (s *TokenStore) Create(name string, credentials NASCredentials) (string, error) {
+	id := randomToken()[:16]
+	secret := randomToken()
+	token := APITokenPrefix + id + "." + secret
+	plaintext, err := json.Marshal(credentials)
+	if err != nil {
+		return "", fmt.Errorf("encoding credentials: %w", err)
+	}
+	sealed, err := s.seal(secret, plaintext)
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[id] = &APIToken{
+		ID:          id,
+		User:        credentials.User,
+		Name:        name,
+		Hash:        hashToken(token),
+		Credentials: sealed,
+		CreatedAt:   s.now(),
+	}
+	if err = s.save(); err != nil {
+		delete(s.tokens, id)
+		return "", err
+	}
+	return token, nil
+}
+
+// Verify returns the stored token and its NAS credentials. The time of last
+// use is only kept in memory until the file is saved again.
+func (s *TokenStore) Verify(token string) (APIToken, NASCredentials, bool) {
+	var credentials NASCredentials
+	id, secret, ok := parseAPIToken(token)
+	if !ok {
+		return APIToken{}, credentials, false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, found := s.tokens[id]
+	if !found || subtle.ConstantTimeCompare([]byte(stored.Hash), []byte(hashToken(token))) != 1 {
+		return APIToken{}, credentials, false
+	}
+	plaintext, err := s.open(secret, stored.Credentials)
+	if err != nil || json.Unmarshal(plaintext, &credentials) != nil {
+		return APIToken{}, credentials, false
+	}
+	stored.LastUsed = s.now()
+	return *stored, credentials, true
+}
+
+// Revoke deletes a token of the user and reports whether it existed. Tokens
+// of other users are left alone.
+func (s *TokenStore) Revoke(user string, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, found := s.tokens[id]
+	if !found || stored.User != user {
+		return false, nil
+	}
+	delete(s.tokens, id)
+	return true, s.save()
+}
+
+func (s *TokenStore) save() error {
+	return writeDataFile(s.path, s.tokens)
+}
+
+// aead derives the AES key of a token from the store key and the secret
+// part of the token.
+func (s *TokenStore) aead(secret string) (cipher.AEAD, error) {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(secret))
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, fmt.Errorf("creating token cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+func (s *TokenStore) seal(secret string, plaintext []byte) ([]byte, error) {
+	aead, err := s.aead(secret)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, _ = rand.Read(nonce)
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func (s *TokenStore) open(secret string, sealed []byte) ([]byte, error) {
+	aead, err := s.aead(secret)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("encrypted credentials too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, nil)
+}
+
+// parseAPIToken splits a token in its id and secret.
+func parseAPIToken(token string) (string, string, bool) {
+	rest, found := strings.CutPrefix(token, APITokenPrefix)
+	if !found {
+		return "", "", false
+	}
+	id, secret, found := strings.Cut(rest, ".")
+	if !found || id == "" || secret == "" {
+		return "", "", false
+	}
+	return id, secret, true
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testTokenStore(t *testing.T) (*TokenStore, string) {
+	t.Helper()
+	dir := t.TempDir()
+	key, err := LoadTokenKey(filepath.Join(dir, "token.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "tokens.json")
+	store, err := NewTokenStore(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store, path
+}
+
+func TestTokenStore(t *testing.T) {
+	store, path := testTokenStore(t)
+	credentials := NASCredentials{User: "user", Pass: "secret-password", DeviceId: "DID"}
+	token, err := store.Create("backup script", credentials)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(content, []byte(token)) || bytes.Contains(content, []byte("secret-password")) {
+		t.Error("token or password stored in clear")
+	}
+
+	key, err := LoadTokenKey(filepath.Join(filepath.Dir(path), "token.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := NewTokenStore(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, verified, ok := reloaded.Verify(token)
+	if !ok {
+		t.Fatal("token not verified after reload")
+	}
+	if verified != credentials || stored.Name != "backup script" || stored.LastUsed.IsZero() {
+		t.Errorf("token %+v with credentials %+v not stored as expected", stored, verified)
+	}
+
+	tokens := reloaded.List("user")
+	if len(tokens) != 1 || tokens[0].ID != stored.ID {
+		t.Fatalf("tokens %+v listed while only %s expected", tokens, stored.ID)
+	}
+	if revoked, _ := reloaded.Revoke("other", stored.ID); revoked {
+		t.Error("token revoked by another user")
+	}
+	if revoked, err := reloaded.Revoke("user", stored.ID); !revoked || err != nil {
+		t.Errorf("token not revoked: %v", err)
+	}
+	if _, _, ok = reloaded.Verify(token); ok {
+		t.Error("revoked token still verified")
+	}
+}
+
+func TestTokenStoreRejectsForgedToken(t *testing.T) {
+	store, _ := testTokenStore(t)
+	token, err := store.Create("script", NASCredentials{User: "user", Pass: "pass"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _, _ := parseAPIToken(token)
+	for _, forged := range []string{"", "forged.token", APITokenPrefix + id, APITokenPrefix + id + ".forged", token[:len(token)-1]} {
+		if _, _, ok := store.Verify(forged); ok {
+			t.Errorf("forged token '%s' verified", forged)
+		}
+	}
+}
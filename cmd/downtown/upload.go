@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// MaxUploadSize limits the size of the .torrent and .nzb files uploaded.
+const MaxUploadSize = 10 * MB
+
+var ErrInvalidUpload = errors.New("only .torrent and .nzb files can be uploaded")
+
+// uploadContentTypes are the content types browsers send for each of the
+// accepted extensions.
+var uploadContentTypes = map[string][]string{
+	".torrent": {"application/x-bittorrent", "application/octet-stream"},
+	".nzb":     {"application/x-nzb", "application/xml", "text/xml", "application/octet-stream"},
+}
+
+// checkUpload accepts .torrent and .nzb files checking the extension, the
+// declared content type and the beginning of the file content. The file is
+// rewound before returning.
+func checkUpload(header *multipart.FileHeader, file io.ReadSeeker) error {
+	ext := strings.ToLower(filepath.Ext(header.Filename))
+	allowed, found := uploadContentTypes[ext]
+	if !found {
+		return ErrInvalidUpload
+	}
+	if declared := header.Header.Get("Content-Type"); declared != "" {
+		contentType, _, err := mime.ParseMediaType(declared)
+		if err != nil || !slices.Contains(allowed, contentType) {
+			return ErrInvalidUpload
+		}
+	}
+
+	head := make([]byte, 1024)
+	n, err := io.ReadFull(file, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return ErrInvalidUpload
+	}
+	head = head[:n]
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	switch ext {
+	case ".torrent":
+		// a torrent file is a bencoded dictionary
+		if !bytes.HasPrefix(head, []byte("d")) {
+			return ErrInvalidUpload
+		}
+	case ".nzb":
+		if !bytes.Contains(head, []byte("<nzb")) {
+			return ErrInvalidUpload
+		}
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+func TestCheckUpload(t *testing.T) {
+	testCases := []struct {
+		fileName    string
+		contentType string
+		content     string
+		valid       bool
+	}{
+		{"debian.torrent", "application/x-bittorrent", "d8:announce", true},
+		{"debian.torrent", "", "d8:announce", true},
+		{"show.nzb", "application/x-nzb", `<?xml version="1.0"?><nzb xmlns="http://www.newzbin.com/DTD/2003/nzb">`, true},
+		{"show.nzb", "text/xml; charset=utf-8", `<?xml version="1.0"?><nzb>`, true},
+		{"debian.torrent", "text/html", "d8:announce", false},
+		{"debian.torrent", "application/x-bittorrent", "<html>", false},
+		{"show.nzb", "application/x-nzb", "d8:announce", false},
+		{"setup.exe", "application/octet-stream", "MZ", false},
+	}
+
+	for _, tc := range testCases {
+		header := &multipart.FileHeader{Filename: tc.fileName, Header: textproto.MIMEHeader{}}
+		if tc.contentType != "" {
+			header.Header.Set("Content-Type", tc.contentType)
+		}
+		file := strings.NewReader(tc.content)
+		err := checkUpload(header, file)
+		if tc.valid && err != nil {
+			t.Errorf("%s (%s) refused: %v", tc.fileName, tc.contentType, err)
+		}
+		if !tc.valid && err == nil {
+			t.Errorf("%s (%s) with content '%s' accepted", tc.fileName, tc.contentType, tc.content)
+		}
+		if tc.valid {
+			content, _ := io.ReadAll(file)
+			if string(content) != tc.content {
+				t.Errorf("%s not rewound after the check", tc.fileName)
+			}
+		}
+	}
+}
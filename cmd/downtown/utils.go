@@ -1,6 +1,11 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+)
 
 const (
 	_  = iota
@@ -31,3 +36,24 @@ func HumanizeSize(bytes int64) string {
 func ProgressPercentage(downloaded, size int64) string {
 	return fmt.Sprintf("%.1f", float64(downloaded)/float64(size)*100)
 }
+
+// linkSchemes are the kinds of links Download Station creates tasks from.
+var linkSchemes = []string{"http", "https", "ftp", "ftps", "sftp", "magnet", "thunder", "flashget", "qqdl", "ed2k"}
+
+// ParseLinks returns the distinct links found one per line in text, along
+// with the lines that aren't a supported link.
+func ParseLinks(text string) (links []string, invalid []string) {
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || slices.Contains(links, line) {
+			continue
+		}
+		u, err := url.Parse(line)
+		if err != nil || !slices.Contains(linkSchemes, strings.ToLower(u.Scheme)) {
+			invalid = append(invalid, line)
+			continue
+		}
+		links = append(links, line)
+	}
+	return links, invalid
+}
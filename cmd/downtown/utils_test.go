@@ -1,6 +1,9 @@
 package main
 
-import "testing"
+import (
+	"slices"
+	"testing"
+)
 
 func TestHumanizeSize(t *testing.T) {
 	testCases := []struct {
@@ -21,3 +24,17 @@ func TestHumanizeSize(t *testing.T) {
 		}
 	}
 }
+
+func TestParseLinks(t *testing.T) {
+	text := "https://example.com/a.iso\r\n\n  magnet:?xt=urn:btih:ABC  \nnot a link\nhttps://example.com/a.iso\nfile:///etc/passwd\n"
+
+	links, invalid := ParseLinks(text)
+	expectedLinks := []string{"https://example.com/a.iso", "magnet:?xt=urn:btih:ABC"}
+	if !slices.Equal(links, expectedLinks) {
+		t.Errorf("links %v parsed while %v expected", links, expectedLinks)
+	}
+	expectedInvalid := []string{"not a link", "file:///etc/passwd"}
+	if !slices.Equal(invalid, expectedInvalid) {
+		t.Errorf("invalid lines %v while %v expected", invalid, expectedInvalid)
+	}
+}
@@ -1,13 +1,25 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"github.com/lazydevorg/downtown/ui"
 	"html/template"
+	"io"
 	"io/fs"
 	"log/slog"
+	"mime/multipart"
 	"net/http"
+	"net/url"
+	"path"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 type TemplateCache map[string]*template.Template
@@ -15,14 +27,29 @@ type TemplateCache map[string]*template.Template
 var templateFunctions = template.FuncMap{
 	"humanSize":          HumanizeSize,
 	"progressPercentage": ProgressPercentage,
+	"taskCard":           func(task Task) TaskCardData { return TaskCardData{Task: task} },
 }
 
-type SidHandlerFunc func(w http.ResponseWriter, r *http.Request, sid string)
+type SessionHandlerFunc func(w http.ResponseWriter, r *http.Request, session *Session)
 
 type WebApp struct {
-	App       *App
-	Logger    *slog.Logger
-	Templates TemplateCache
+	App        *App
+	Logger     *slog.Logger
+	Templates  TemplateCache
+	Sessions   *SessionStore
+	Favourites *FavouritesStore
+	Tokens     *TokenStore
+	TasksCache *TasksCache
+	TaskFeeds  *TaskFeeds
+	// EventsKeepAlive is how often the task events check the session and
+	// keep the stream alive, EventsKeepAliveInterval when zero.
+	EventsKeepAlive time.Duration
+
+	// tokenSessions maps the id of an API token to the token of the session
+	// opened by logging in with its credentials. tokenLogins holds a mutex
+	// per token id so concurrent requests share a single login.
+	tokenSessions sync.Map
+	tokenLogins   sync.Map
 }
 
 func (a *WebApp) routes() http.Handler {
@@ -31,11 +58,26 @@ func (a *WebApp) routes() http.Handler {
 	mux.HandleFunc("GET /login", a.loginPage)
 	mux.HandleFunc("POST /login", a.login)
 	mux.HandleFunc("GET /logout", a.logout)
-	mux.HandleFunc("GET /tasks", authenticated(a.tasks))
-	mux.HandleFunc("POST /tasks", authenticated(a.newTask))
-	mux.HandleFunc("DELETE /tasks/{id}", authenticated(a.deleteTask))
-	mux.HandleFunc("PUT /tasks/{id}/pause", authenticated(a.pauseTask))
-	mux.HandleFunc("PUT /tasks/{id}/resume", authenticated(a.resumeTask))
+	mux.HandleFunc("GET /tasks", a.authenticated(a.tasks))
+	mux.HandleFunc("POST /tasks", a.authenticated(a.newTask))
+	mux.HandleFunc("PUT /tasks/pause", a.authenticated(a.changeTasks("pause")))
+	mux.HandleFunc("PUT /tasks/resume", a.authenticated(a.changeTasks("resume")))
+	mux.HandleFunc("DELETE /tasks", a.authenticated(a.changeTasks("delete")))
+	mux.HandleFunc("GET /tasks/events", a.authenticatedEvents(a.taskEvents))
+	mux.HandleFunc("GET /tasks/{id}", a.authenticated(a.task))
+	mux.HandleFunc("GET /tasks/{id}/status", a.authenticated(a.taskStatus))
+	mux.HandleFunc("PUT /tasks/{id}/files", a.authenticated(a.updateTaskFiles))
+	mux.HandleFunc("PUT /tasks/{id}/destination", a.authenticated(a.moveTask))
+	mux.HandleFunc("DELETE /tasks/{id}", a.authenticated(a.deleteTask))
+	mux.HandleFunc("PUT /tasks/{id}/pause", a.authenticated(a.pauseTask))
+	mux.HandleFunc("PUT /tasks/{id}/resume", a.authenticated(a.resumeTask))
+	mux.HandleFunc("GET /folders", a.authenticated(a.folders))
+	mux.HandleFunc("POST /favourites", a.authenticated(a.addFavourite))
+	mux.HandleFunc("DELETE /favourites", a.authenticated(a.removeFavourite))
+	mux.HandleFunc("GET /settings", a.authenticated(a.settings))
+	mux.HandleFunc("POST /settings/tokens", a.authenticated(a.newToken))
+	mux.HandleFunc("DELETE /settings/tokens/{id}", a.authenticated(a.revokeToken))
+	a.apiRoutes(mux)
 	mux.HandleFunc("GET /up", a.health)
 	mux.HandleFunc("/", a.notFound)
 	return a.logRequests(mux)
@@ -49,15 +91,122 @@ func (a *WebApp) renderTemplate(w http.ResponseWriter, name string, data any) {
 	}
 }
 
-func (a *WebApp) renderError(w http.ResponseWriter, serverError error) {
-	w.WriteHeader(http.StatusInternalServerError)
+// renderFragment renders a template defined inside a page, used to answer
+// htmx requests with just the part of the page that changed.
+func (a *WebApp) renderFragment(w http.ResponseWriter, page string, name string, data any) {
+	ts := a.Templates[page]
+	err := ts.ExecuteTemplate(w, name, data)
+	if err != nil {
+		panic("error rendering template: " + err.Error())
+	}
+}
+
+func (a *WebApp) renderError(w http.ResponseWriter, r *http.Request, serverError error) {
+	if errors.Is(serverError, ErrSessionExpired) {
+		a.Logger.Info("download station session expired", "uri", r.URL.Path)
+		a.endSession(w, r)
+		redirectToLogin(w, r, true)
+		return
+	}
+	status, message := errorResponse(serverError)
+	a.renderErrorPage(w, status, message)
+}
+
+type ErrorPageData struct {
+	Status  int
+	Message string
+}
+
+func (a *WebApp) renderErrorPage(w http.ResponseWriter, status int, message string) {
+	w.WriteHeader(status)
 	ts := a.Templates["error.html"]
-	err := ts.ExecuteTemplate(w, "error.html", serverError)
+	err := ts.ExecuteTemplate(w, "error.html", ErrorPageData{Status: status, Message: message})
 	if err != nil {
 		panic("error rendering template: " + err.Error())
 	}
 }
 
+// errorResponse maps an error to the HTTP status and the message shown to
+// the user. Download Station errors are described by their code, failures
+// reaching the NAS are reported as a bad gateway.
+func errorResponse(err error) (int, string) {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErrorStatus(apiErr), apiErr.Message()
+	}
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return http.StatusBadGateway, "Download Station is not reachable"
+	}
+	return http.StatusInternalServerError, err.Error()
+}
+
+var apiErrorStatuses = map[string]map[int]int{
+	AuthAPI: {
+		400: http.StatusUnauthorized,
+		401: http.StatusForbidden,
+		402: http.StatusForbidden,
+		406: http.StatusForbidden,
+		407: http.StatusForbidden,
+		408: http.StatusForbidden,
+		409: http.StatusForbidden,
+		410: http.StatusForbidden,
+	},
+	FileListAPI:   fileStationErrorStatuses,
+	FileDeleteAPI: fileStationErrorStatuses,
+	TaskAPI:       taskErrorStatuses,
+	Task2API:      taskErrorStatuses,
+	BTFileAPI:     taskErrorStatuses,
+	LocationAPI:   taskErrorStatuses,
+}
+
+var taskErrorStatuses = map[int]int{
+	400: http.StatusBadRequest,
+	401: http.StatusConflict,
+	402: http.StatusForbidden,
+	403: http.StatusBadRequest,
+	404: http.StatusNotFound,
+	405: http.StatusConflict,
+	406: http.StatusBadRequest,
+	407: http.StatusBadRequest,
+	408: http.StatusNotFound,
+}
+
+var fileStationErrorStatuses = map[int]int{
+	400: http.StatusBadRequest,
+	402: http.StatusServiceUnavailable,
+	407: http.StatusForbidden,
+	408: http.StatusNotFound,
+	418: http.StatusBadRequest,
+}
+
+var commonErrorStatuses = map[int]int{
+	101: http.StatusBadRequest,
+	105: http.StatusUnauthorized,
+	106: http.StatusUnauthorized,
+	107: http.StatusUnauthorized,
+	108: http.StatusBadRequest,
+	109: http.StatusServiceUnavailable,
+	110: http.StatusServiceUnavailable,
+	111: http.StatusServiceUnavailable,
+	114: http.StatusBadRequest,
+	115: http.StatusForbidden,
+	116: http.StatusForbidden,
+	117: http.StatusServiceUnavailable,
+	118: http.StatusServiceUnavailable,
+	119: http.StatusUnauthorized,
+}
+
+func apiErrorStatus(err *APIError) int {
+	if status, found := apiErrorStatuses[err.API][err.Code]; found {
+		return status
+	}
+	if status, found := commonErrorStatuses[err.Code]; found {
+		return status
+	}
+	return http.StatusBadGateway
+}
+
 func (a *WebApp) logRequests(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		a.Logger.Debug("request received", "method", r.Method, "uri", r.URL.Path)
@@ -66,9 +215,9 @@ func (a *WebApp) logRequests(next http.Handler) http.Handler {
 }
 
 func (a *WebApp) home(w http.ResponseWriter, r *http.Request) {
-	_, err := r.Cookie("sid")
+	_, found := a.session(r)
 	w.Header().Add("Cache-Control", "no-cache, no-store, must-revalidate")
-	if err != nil {
+	if !found {
 		http.Redirect(w, r, "/login", http.StatusFound)
 	} else {
 		http.Redirect(w, r, "/tasks", http.StatusFound)
@@ -76,123 +225,1276 @@ func (a *WebApp) home(w http.ResponseWriter, r *http.Request) {
 
 }
 
-func (a *WebApp) loginPage(w http.ResponseWriter, _ *http.Request) {
-	a.renderTemplate(w, "login.html", nil)
+// DeviceCookieName stores the DSM device token returned after a 2-step
+// verification so that the browser is trusted on the following logins.
+const DeviceCookieName = "device_token"
+
+type LoginPageData struct {
+	Expired bool
+	Next    string
+
+	// OTP is set when the account requires a 2-step verification code. The
+	// credentials stay on the server, the second step of the form carries
+	// the token of the pending login.
+	OTP        bool
+	OTPInvalid bool
+	User       string
+	LoginToken string
+
+	Error string
+}
+
+func (a *WebApp) loginPage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Cache-Control", "no-cache, no-store, must-revalidate")
+	a.renderTemplate(w, "login.html", LoginPageData{
+		Expired: r.URL.Query().Get("expired") == "1",
+		Next:    safeRedirectPath(r.URL.Query().Get("next")),
+	})
 }
 
 func (a *WebApp) login(w http.ResponseWriter, r *http.Request) {
 	user := r.FormValue("user")
 	pass := r.FormValue("pass")
-	response, err := a.App.Client.Login(r.Context(), LoginRequest{
-		user: user,
-		pass: pass,
-	})
+	loginToken := r.FormValue("login")
+	if loginToken != "" {
+		pending, found := a.Sessions.Pending(loginToken)
+		if !found {
+			w.Header().Add("Cache-Control", "no-cache, no-store, must-revalidate")
+			w.WriteHeader(http.StatusUnauthorized)
+			a.renderTemplate(w, "login.html", LoginPageData{
+				Next:  safeRedirectPath(r.FormValue("next")),
+				Error: "The login took too long, please enter your credentials again",
+			})
+			return
+		}
+		user, pass = pending.User, pending.Pass
+	}
+	loginRequest := LoginRequest{
+		user:        user,
+		pass:        pass,
+		otpCode:     r.FormValue("otp"),
+		trustDevice: r.FormValue("trust") == "on",
+	}
+	if deviceCookie, err := r.Cookie(DeviceCookieName); err == nil {
+		loginRequest.deviceId = deviceCookie.Value
+	}
+	response, err := a.App.Client.Login(r.Context(), loginRequest)
+	if errors.Is(err, ErrOTPRequired) || errors.Is(err, ErrOTPInvalid) {
+		if loginToken == "" {
+			loginToken = a.Sessions.CreatePending(user, pass)
+		}
+		w.Header().Add("Cache-Control", "no-cache, no-store, must-revalidate")
+		a.renderTemplate(w, "login.html", LoginPageData{
+			Next:       safeRedirectPath(r.FormValue("next")),
+			OTP:        true,
+			OTPInvalid: errors.Is(err, ErrOTPInvalid),
+			User:       user,
+			LoginToken: loginToken,
+		})
+		return
+	}
+	if loginToken != "" {
+		a.Sessions.DeletePending(loginToken)
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		a.Logger.Warn("login refused", "user", user, "error", err)
+		w.Header().Add("Cache-Control", "no-cache, no-store, must-revalidate")
+		w.WriteHeader(apiErrorStatus(apiErr))
+		a.renderTemplate(w, "login.html", LoginPageData{
+			Next:  safeRedirectPath(r.FormValue("next")),
+			Error: apiErr.Message(),
+		})
+		return
+	}
 	if err != nil {
 		a.Logger.Error("login error", "error", err)
-		a.renderError(w, err)
+		a.renderError(w, r, err)
 		return
 	}
+	if response.Data.DID != "" {
+		http.SetCookie(w, &http.Cookie{
+			Name:     DeviceCookieName,
+			Value:    response.Data.DID,
+			Path:     "/login",
+			MaxAge:   365 * 24 * 60 * 60,
+			HttpOnly: true,
+			Secure:   a.secureCookies(r),
+			SameSite: http.SameSiteStrictMode,
+		})
+	}
+	_, token := a.Sessions.Create(user, response.Data.SID)
 	http.SetCookie(w, &http.Cookie{
-		Name:  "sid",
-		Value: response.Data.SID,
+		Name:     SessionCookieName,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(a.App.Config.sessionMaxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   a.secureCookies(r),
+		SameSite: http.SameSiteLaxMode,
 	})
-	http.Redirect(w, r, "/", http.StatusFound)
+	http.Redirect(w, r, safeRedirectPath(r.FormValue("next")), http.StatusFound)
 }
 
 func (a *WebApp) logout(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("Cache-Control", "no-cache, no-store, must-revalidate")
-	http.SetCookie(w, &http.Cookie{
-		Name:   "sid",
-		Value:  "",
-		MaxAge: -1,
-	})
+	if session, found := a.session(r); found {
+		err := a.App.Client.Logout(r.Context(), session.SID)
+		if err != nil {
+			a.Logger.Warn("download station logout error", "error", err)
+		}
+	}
+	a.endSession(w, r)
 	http.Redirect(w, r, "/", http.StatusFound)
 }
 
-func (a *WebApp) tasks(w http.ResponseWriter, r *http.Request, sid string) {
-	var tasksResponse Response[TasksData]
-	err := a.App.Client.GetTasks(r.Context(), sid, &tasksResponse)
+type TasksPageData struct {
+	Tasks      []Task
+	Results    []CreateResult
+	Bulk       BulkResultsData
+	Favourites []Favourite
+	Filter     TaskFilterData
+	Pagination PaginationData
+
+	// ListQuery selects the filter and the page of the list, kept by the
+	// live updates.
+	ListQuery string
+}
+
+// TasksPageSize is the number of tasks shown in a page of the list.
+const TasksPageSize = 50
+
+// PaginationData describes the page of the tasks list shown, From and To
+// count from 1.
+type PaginationData struct {
+	Page        int
+	Pages       int
+	Total       int
+	From        int
+	To          int
+	PreviousURL string
+	NextURL     string
+}
+
+// TaskFilterData is the filter of the tasks list along with the choices of
+// its controls. The Owners are those of the tasks fetched, only the ones of
+// the page shown when the list isn't filtered.
+type TaskFilterData struct {
+	TaskFilter
+	Query    string
+	Statuses []string
+	Types    []string
+	Owners   []string
+	Sorts    []string
+}
+
+// TaskFilterCookieName keeps the last filter of the tasks list so it's
+// applied when the list is opened or refreshed without one.
+const TaskFilterCookieName = "task_filter"
+
+// CreateResult reports the outcome of adding a link or a file.
+type CreateResult struct {
+	Source string `json:"source"`
+	Error  string `json:"error,omitempty"`
+}
+
+func (a *WebApp) tasks(w http.ResponseWriter, r *http.Request, session *Session) {
+	a.renderTasksPage(w, r, session, a.Sessions.TakeFlash(session).Results)
+}
+
+func (a *WebApp) renderTasksPage(w http.ResponseWriter, r *http.Request, session *Session, results []CreateResult) {
+	filter, fromQuery := taskFilter(r)
+	page := requestPage(r)
+	data, err := a.TasksCache.GetPage(r.Context(), session.User, session.SID, tasksListPage(filter, page))
 	if err != nil {
 		a.Logger.Error("tasks error", "error", err)
-		a.renderError(w, err)
+		a.renderError(w, r, err)
+		return
+	}
+	tasks, total := pageTasks(filter, page, data)
+	// the tasks shrank since the page was linked, show the last one
+	if pages := pageCount(total); page > pages {
+		page = pages
+		data, err = a.TasksCache.GetPage(r.Context(), session.User, session.SID, tasksListPage(filter, page))
+		if err != nil {
+			a.Logger.Error("tasks error", "error", err)
+			a.renderError(w, r, err)
+			return
+		}
+		tasks, total = pageTasks(filter, page, data)
+	}
+
+	if fromQuery {
+		a.saveTaskFilter(w, r, filter)
+	}
+	if results == nil {
+		w.Header().Add("Cache-Control", "max-age=5")
+		w.Header().Add("Vary", "Cookie")
+	}
+	a.renderTemplate(w, "tasks.html", TasksPageData{
+		Tasks:      tasks,
+		Results:    results,
+		Favourites: a.Favourites.List(session.User),
+		Filter: TaskFilterData{
+			TaskFilter: filter,
+			Query:      filter.Values().Encode(),
+			Statuses:   TaskStatusFilters,
+			Types:      TaskTypeFilters,
+			Owners:     taskOwners(data.Tasks),
+			Sorts:      TaskSorts,
+		},
+		Pagination: PaginationData{
+			Page:        page,
+			Pages:       pageCount(total),
+			Total:       total,
+			From:        min((page-1)*TasksPageSize+1, total),
+			To:          (page-1)*TasksPageSize + len(tasks),
+			PreviousURL: "/tasks?" + tasksListQuery(filter, page-1),
+			NextURL:     "/tasks?" + tasksListQuery(filter, page+1),
+		},
+		ListQuery: tasksListQuery(filter, page),
+	})
+}
+
+// requestPage reads the page of the tasks list, the first one when missing.
+func requestPage(r *http.Request) int {
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		return 1
+	}
+	return page
+}
+
+func pageCount(total int) int {
+	return max(1, (total+TasksPageSize-1)/TasksPageSize)
+}
+
+// tasksListPage is the part of the tasks fetched to show a page of the list.
+// Without a filter it's just the page, Download Station can't filter nor
+// sort the tasks so all of them are needed otherwise, even for a sort
+// remembered in the cookie.
+func tasksListPage(filter TaskFilter, page int) TasksPage {
+	if filter.IsZero() {
+		return TasksPage{Offset: (page - 1) * TasksPageSize, Limit: TasksPageSize}
+	}
+	return TasksPage{}
+}
+
+// pageTasks returns the tasks of the page out of the ones fetched for it and
+// the count of all the tasks matching the filter.
+func pageTasks(filter TaskFilter, page int, data TasksData) ([]Task, int) {
+	if filter.IsZero() {
+		return data.Tasks, data.Total
+	}
+	filtered := filter.Apply(data.Tasks)
+	start := min((page-1)*TasksPageSize, len(filtered))
+	end := min(start+TasksPageSize, len(filtered))
+	return filtered[start:end], len(filtered)
+}
+
+func tasksListQuery(filter TaskFilter, page int) string {
+	values := filter.Values()
+	if page > 1 {
+		values.Set("page", strconv.Itoa(page))
+	}
+	return values.Encode()
+}
+
+// taskFilter reads the filter of the tasks list from the query, falling back
+// to the one saved in the cookie, and tells whether it came from the query.
+func taskFilter(r *http.Request) (TaskFilter, bool) {
+	query := r.URL.Query()
+	if HasTaskFilter(query) {
+		return ParseTaskFilter(query), true
+	}
+	if cookie, err := r.Cookie(TaskFilterCookieName); err == nil {
+		if values, err := url.ParseQuery(cookie.Value); err == nil {
+			return ParseTaskFilter(values), false
+		}
+	}
+	return TaskFilter{}, false
+}
+
+func (a *WebApp) saveTaskFilter(w http.ResponseWriter, r *http.Request, filter TaskFilter) {
+	cookie := &http.Cookie{
+		Name:     TaskFilterCookieName,
+		Value:    filter.Values().Encode(),
+		Path:     "/tasks",
+		MaxAge:   365 * 24 * 60 * 60,
+		HttpOnly: true,
+		Secure:   a.secureCookies(r),
+		SameSite: http.SameSiteLaxMode,
+	}
+	if filter.IsZero() {
+		cookie.MaxAge = -1
+	}
+	http.SetCookie(w, cookie)
+}
+
+type TaskEventsData struct {
+	Reset   bool
+	Tasks   []Task
+	Added   []Task
+	Changed []TaskCardData
+	Removed []string
+}
+
+// EventsKeepAliveInterval is how often the task events check that the
+// session is still open and send a comment, proxies close idle streams.
+const EventsKeepAliveInterval = 20 * time.Second
+
+// taskEvents streams the changes of the tasks as server-sent events carrying
+// the task cards to swap out of band. The first event replaces the whole
+// list, the page may have been rendered from an older snapshot, and so do
+// the ones adding tasks or changing their order. An expired event ends the
+// stream when the Download Station session expires or the Downtown session
+// ends, checked on every update and periodically.
+func (a *WebApp) taskEvents(w http.ResponseWriter, r *http.Request, session *Session) {
+	controller := http.NewResponseController(w)
+	// the server write timeout would otherwise end the stream
+	_ = controller.SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := controller.Flush(); err != nil {
+		return
+	}
+
+	expired := func() {
+		if writeEvent(w, "expired", "") == nil {
+			_ = controller.Flush()
+		}
+	}
+	// the session can end while the stream is open, on logout or timeout
+	cookie, _ := r.Cookie(SessionCookieName)
+	keepAlive := a.EventsKeepAlive
+	if keepAlive <= 0 {
+		keepAlive = EventsKeepAliveInterval
+	}
+	ticker := time.NewTicker(keepAlive)
+	defer ticker.Stop()
+
+	filter, _ := taskFilter(r)
+	page := requestPage(r)
+	subscription := a.TaskFeeds.Subscribe(session.User, session.SID, tasksListPage(filter, page))
+	defer subscription.Unsubscribe()
+	var sent []Task
+	synced := false
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+			if !a.Sessions.Active(cookie.Value) {
+				expired()
+				return
+			}
+			// a comment, so that proxies don't close an idle stream
+			if _, err := io.WriteString(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			if err := controller.Flush(); err != nil {
+				return
+			}
+			continue
+		case <-subscription.Updates:
+		}
+		if !a.Sessions.Active(cookie.Value) {
+			expired()
+			return
+		}
+		all, err := subscription.Tasks()
+		if err != nil {
+			a.Logger.Info("download station session expired", "uri", r.URL.Path)
+			expired()
+			return
+		}
+		tasks, _ := pageTasks(filter, page, TasksData{Tasks: all})
+		data := TaskEventsData{Reset: !synced, Tasks: tasks}
+		if synced {
+			var changed []Task
+			data.Added, changed, data.Removed = diffTasks(sent, tasks)
+			if len(data.Added)+len(changed)+len(data.Removed) == 0 {
+				continue
+			}
+			data.Reset = len(data.Added) > 0 || len(tasks) == 0 || !sameTaskOrder(sent, tasks)
+			for _, task := range changed {
+				data.Changed = append(data.Changed, TaskCardData{Task: task, OOB: true})
+			}
+		}
+		var buf bytes.Buffer
+		if err = a.Templates["tasks.html"].ExecuteTemplate(&buf, "task-events", data); err != nil {
+			panic("error rendering template: " + err.Error())
+		}
+		if err = writeEvent(w, "tasks", buf.String()); err != nil {
+			return
+		}
+		if err = controller.Flush(); err != nil {
+			return
+		}
+		sent, synced = tasks, true
+	}
+}
+
+// sameTaskOrder tells whether the tasks found in both lists are in the same
+// order.
+func sameTaskOrder(previous, tasks []Task) bool {
+	positions := make(map[string]int, len(tasks))
+	for i, task := range tasks {
+		positions[task.Id] = i
+	}
+	last := -1
+	for _, task := range previous {
+		position, found := positions[task.Id]
+		if !found {
+			continue
+		}
+		if position < last {
+			return false
+		}
+		last = position
+	}
+	return true
+}
+
+// writeEvent writes a server-sent event, every line of data in its own data
+// field.
+func writeEvent(w io.Writer, event string, data string) error {
+	var buf bytes.Buffer
+	buf.WriteString("event: " + event + "\n")
+	for _, line := range strings.Split(data, "\n") {
+		buf.WriteString("data: " + line + "\n")
+	}
+	buf.WriteString("\n")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// newTask adds the links submitted one per line and the uploaded file. The
+// outcome of each link and of the file is reported back: htmx requests get
+// just the results, the others are redirected to the tasks page showing them.
+func (a *WebApp) newTask(w http.ResponseWriter, r *http.Request, session *Session) {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		r.Body = http.MaxBytesReader(w, r.Body, MaxUploadSize+64*KB)
+		err := r.ParseMultipartForm(MaxUploadSize)
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			a.renderUploadTooLarge(w, r, session)
+			return
+		}
+		if err != nil {
+			a.renderErrorPage(w, http.StatusBadRequest, "Invalid upload")
+			return
+		}
+	}
+
+	links, invalid := ParseLinks(r.FormValue("urls"))
+	input := newTasks{
+		links:       links,
+		invalid:     invalid,
+		destination: r.FormValue("destination"),
+	}
+	file, header, err := r.FormFile("file")
+	if err == nil {
+		defer func() {
+			_ = file.Close()
+		}()
+		// the body limit leaves room for the other fields, the file itself
+		// may still be over the limit
+		if header.Size > MaxUploadSize {
+			a.renderUploadTooLarge(w, r, session)
+			return
+		}
+		input.file, input.header = file, header
+	}
+	results, err := a.createTasks(r.Context(), session, input)
+	if err != nil {
+		a.renderError(w, r, err)
+		return
+	}
+	if len(results) == 0 {
+		results = append(results, CreateResult{Error: "Enter some links or choose a .torrent or .nzb file"})
+	}
+	a.renderCreateResults(w, r, session, results)
+}
+
+// newTasks are the links and the file to create tasks from.
+type newTasks struct {
+	links       []string
+	invalid     []string
+	destination string
+	file        multipart.File
+	header      *multipart.FileHeader
+}
+
+// createTasks creates the tasks and reports the outcome of each link and of
+// the file. The links are created with a single request, when it fails each
+// link is tried on its own to tell which ones were refused. An error is only
+// returned when the session expired.
+func (a *WebApp) createTasks(ctx context.Context, session *Session, input newTasks) ([]CreateResult, error) {
+	var results []CreateResult
+	destination := strings.Trim(input.destination, "/")
+	for _, line := range input.invalid {
+		results = append(results, CreateResult{Source: line, Error: "Not a supported link"})
+	}
+	if len(input.links) > 0 {
+		linkResults, err := a.createLinks(ctx, session, input.links, destination)
+		a.tasksChanged(session)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, linkResults...)
+	}
+
+	if input.file != nil {
+		if err := checkUpload(input.header, input.file); err != nil {
+			a.Logger.Warn("upload refused", "file", input.header.Filename, "error", err)
+			results = append(results, CreateResult{Source: input.header.Filename, Error: "Only .torrent and .nzb files can be uploaded"})
+		} else {
+			_, err := a.App.Client.CreateTask(ctx, session.SID, TaskCreateRequest{File: input.file, FileName: input.header.Filename, Destination: destination})
+			a.tasksChanged(session)
+			if errors.Is(err, ErrSessionExpired) {
+				return nil, err
+			}
+			if err != nil {
+				a.Logger.Error("new task error", "error", err)
+			}
+			results = append(results, createResult(input.header.Filename, err))
+		}
+	}
+	return results, nil
+}
+
+// createLinks creates tasks from all the links with one request. When it
+// fails and there are several links, they're created one by one so the
+// error is reported for the links it belongs to.
+func (a *WebApp) createLinks(ctx context.Context, session *Session, links []string, destination string) ([]CreateResult, error) {
+	_, err := a.App.Client.CreateTask(ctx, session.SID, TaskCreateRequest{Uris: links, Destination: destination})
+	if errors.Is(err, ErrSessionExpired) {
+		return nil, err
+	}
+	if err == nil || len(links) == 1 {
+		if err != nil {
+			a.Logger.Error("new task error", "error", err)
+		}
+		results := make([]CreateResult, len(links))
+		for i, link := range links {
+			results[i] = createResult(link, err)
+		}
+		return results, nil
+	}
+
+	a.Logger.Warn("new tasks error, creating the links one by one", "error", err)
+	results := make([]CreateResult, len(links))
+	for i, link := range links {
+		_, err = a.App.Client.CreateTask(ctx, session.SID, TaskCreateRequest{Uris: []string{link}, Destination: destination})
+		if errors.Is(err, ErrSessionExpired) {
+			return nil, err
+		}
+		if err != nil {
+			a.Logger.Error("new task error", "link", link, "error", err)
+		}
+		results[i] = createResult(link, err)
+	}
+	return results, nil
+}
+
+func createResult(source string, err error) CreateResult {
+	if err == nil {
+		return CreateResult{Source: source}
+	}
+	_, message := errorResponse(err)
+	return CreateResult{Source: source, Error: message}
+}
+
+// renderUploadTooLarge reports a file over MaxUploadSize, htmx requests get
+// the results with a 413 status.
+func (a *WebApp) renderUploadTooLarge(w http.ResponseWriter, r *http.Request, session *Session) {
+	results := []CreateResult{{Source: "Upload", Error: "Files can't be larger than " + HumanizeSize(MaxUploadSize)}}
+	if r.Header.Get("HX-Request") == "true" {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+	}
+	a.renderCreateResults(w, r, session, results)
+}
+
+func (a *WebApp) renderCreateResults(w http.ResponseWriter, r *http.Request, session *Session, results []CreateResult) {
+	if r.Header.Get("HX-Request") == "true" {
+		a.renderFragment(w, "tasks.html", "create-results", results)
+		return
+	}
+	a.Sessions.SetFlash(session, Flash{Results: results})
+	http.Redirect(w, r, "/tasks", http.StatusSeeOther)
+}
+
+// BulkResultsData reports the outcome of a change applied to several tasks.
+// The Cards of the tasks that failed are swapped out of band showing the
+// error.
+type BulkResultsData struct {
+	Message string
+	Cards   []TaskCardData
+}
+
+// TaskCardData is a task of the tasks list. OOB renders the card to be
+// swapped out of band by htmx.
+type TaskCardData struct {
+	Task  Task
+	Error string
+	OOB   bool
+}
+
+// bulkScopes select the tasks a change applies to when the shortcuts are
+// used instead of picking the tasks.
+var bulkScopes = map[string]map[string]func(Task) bool{
+	"pause": {
+		"all": func(t Task) bool { return !slices.Contains([]string{"paused", "finished", "error"}, t.Status) },
+	},
+	"resume": {
+		"all": func(t Task) bool { return t.Status == "paused" },
+	},
+	"delete": {
+		"finished": func(t Task) bool { return t.Status == "finished" },
+	},
+}
+
+var bulkDone = map[string]string{
+	"pause":  "Paused",
+	"resume": "Resumed",
+	"delete": "Deleted",
+}
+
+// changeTasks pauses, resumes or deletes the selected tasks, or the ones in
+// the scope of a shortcut, with a single request. When all the tasks are
+// changed the tasks list is told to refresh through the tasks-changed event,
+// otherwise the cards of the failed tasks show their error.
+func (a *WebApp) changeTasks(method string) SessionHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, session *Session) {
+		if err := r.ParseForm(); err != nil {
+			a.renderErrorPage(w, http.StatusBadRequest, "Invalid tasks selection")
+			return
+		}
+		ids := r.Form["ids"]
+		inScope := bulkScopes[method][r.Form.Get("scope")]
+		if len(ids) == 0 && inScope != nil {
+			current, err := a.TasksCache.Get(r.Context(), session.User, session.SID)
+			if err != nil {
+				a.Logger.Error("tasks error", "error", err)
+				a.renderError(w, r, err)
+				return
+			}
+			for _, task := range current.Tasks {
+				if inScope(task) {
+					ids = append(ids, task.Id)
+				}
+			}
+		}
+
+		var data BulkResultsData
+		if len(ids) == 0 {
+			data.Message = "No tasks to change"
+			a.renderBulkResults(w, r, data)
+			return
+		}
+		var results TaskChangeData
+		var err error
+		switch method {
+		case "pause":
+			results, err = a.App.Client.PauseTasks(r.Context(), session.SID, ids)
+		case "resume":
+			results, err = a.App.Client.ResumeTasks(r.Context(), session.SID, ids)
+		case "delete":
+			results, err = a.App.Client.DeleteTasks(r.Context(), session.SID, ids, deleteOptions(r))
+		}
+		a.tasksChanged(session)
+		if err != nil {
+			a.Logger.Error("tasks "+method+" error", "error", err)
+		}
+		if err != nil && (results == nil || errors.Is(err, ErrSessionExpired)) {
+			a.renderError(w, r, err)
+			return
+		}
+		// a successful reply without the results of the tasks
+		if results == nil {
+			a.Logger.Error("tasks "+method+" error", "error", "no results")
+			a.renderErrorPage(w, http.StatusBadGateway, "Download Station didn't report the outcome of the changes")
+			return
+		}
+		var taskErrs TaskErrors
+		errors.As(err, &taskErrs)
+		data.Cards = a.failedTaskCards(r.Context(), session, taskErrs)
+		data.Message = fmt.Sprintf("%s %d of %d tasks", bulkDone[method], len(results)-len(taskErrs), len(ids))
+		if _, onlyTasks := err.(TaskErrors); err != nil && !onlyTasks {
+			_, message := errorResponse(err)
+			data.Message += ", " + message
+		}
+		if len(taskErrs) == 0 {
+			w.Header().Set("HX-Trigger", "tasks-changed")
+		}
+		a.renderBulkResults(w, r, data)
+	}
+}
+
+// failedTaskCards are the cards of the tasks a change failed for, showing
+// the error. The tasks are only fetched when there are failures.
+func (a *WebApp) failedTaskCards(ctx context.Context, session *Session, taskErrs TaskErrors) []TaskCardData {
+	if len(taskErrs) == 0 {
+		return nil
+	}
+	current, err := a.TasksCache.Get(ctx, session.User, session.SID)
+	if err != nil {
+		a.Logger.Warn("tasks error", "error", err)
+		return nil
+	}
+	tasks := make(map[string]Task, len(current.Tasks))
+	for _, task := range current.Tasks {
+		tasks[task.Id] = task
+	}
+	var cards []TaskCardData
+	for _, taskErr := range taskErrs {
+		if task, found := tasks[taskErr.Id]; found {
+			cards = append(cards, TaskCardData{Task: task, Error: taskErr.Err.Message(), OOB: true})
+		}
+	}
+	return cards
+}
+
+func (a *WebApp) renderBulkResults(w http.ResponseWriter, r *http.Request, data BulkResultsData) {
+	if r.Header.Get("HX-Request") != "true" {
+		http.Redirect(w, r, "/tasks", http.StatusSeeOther)
 		return
 	}
+	a.renderFragment(w, "tasks.html", "bulk-results", data)
+}
+
+type TaskPageData struct {
+	Task        *Task
+	Files       TaskFilesData
+	Destination TaskDestinationData
+}
 
+type TaskDestinationData struct {
+	TaskId      string
+	Destination string
+	Moved       bool
+	Error       string
+}
+
+// TaskFilesData lists the files of a task, Editable when their selection and
+// priority can be changed.
+type TaskFilesData struct {
+	TaskId     string
+	Files      []TaskFile
+	Editable   bool
+	Priorities []string
+	Error      string
+}
+
+func (a *WebApp) task(w http.ResponseWriter, r *http.Request, session *Session) {
+	task, err := a.App.Client.GetTask(r.Context(), session.SID, r.PathValue("id"))
+	if err != nil {
+		a.Logger.Error("task error", "error", err)
+		a.renderError(w, r, err)
+		return
+	}
+	files := TaskFilesData{TaskId: task.Id, Files: task.Additional.Files}
+	if task.Type == "bt" {
+		btFiles, err := a.App.Client.ListTaskFiles(r.Context(), session.SID, task.Id)
+		switch {
+		case err == nil:
+			files = TaskFilesData{TaskId: task.Id, Files: btFiles, Editable: true, Priorities: FilePriorities}
+		case !errors.Is(err, ErrAPIUnavailable):
+			a.Logger.Error("task files error", "error", err)
+			a.renderError(w, r, err)
+			return
+		}
+	}
 	w.Header().Add("Cache-Control", "max-age=5")
-	//w.WriteHeader(401)
-	a.renderTemplate(w, "tasks.html", tasksResponse.Data)
+	a.renderTemplate(w, "task.html", TaskPageData{
+		Task:        task,
+		Files:       files,
+		Destination: TaskDestinationData{TaskId: task.Id, Destination: task.Additional.Detail.Destination},
+	})
 }
 
-func (a *WebApp) newTask(w http.ResponseWriter, r *http.Request, sid string) {
-	url := r.FormValue("url")
-	response, err := a.App.Client.CreateTask(r.Context(), sid, TaskCreateRequest{Uri: url})
+// taskStatus renders the status of a task polled by its page, without the
+// files listed only once when the page is opened.
+func (a *WebApp) taskStatus(w http.ResponseWriter, r *http.Request, session *Session) {
+	task, err := a.App.Client.GetTask(r.Context(), session.SID, r.PathValue("id"))
 	if err != nil {
-		a.Logger.Error("new task error", "error", err)
-		a.renderError(w, err)
+		a.Logger.Error("task error", "error", err)
+		a.renderError(w, r, err)
 		return
 	}
-	if !response.Success {
-		a.Logger.Error("new task error", "code", response.Error.Code)
-		a.renderError(w, fmt.Errorf("new task error: %d", response.Error.Code))
+	w.Header().Add("Cache-Control", "max-age=5")
+	a.renderFragment(w, "task.html", "task-status", task)
+}
+
+// moveTask changes the destination folder of a task. htmx requests get the
+// destination form back with the outcome.
+func (a *WebApp) moveTask(w http.ResponseWriter, r *http.Request, session *Session) {
+	id := r.PathValue("id")
+	htmx := r.Header.Get("HX-Request") == "true"
+	data := TaskDestinationData{TaskId: id, Destination: strings.Trim(r.FormValue("destination"), "/")}
+	if data.Destination == "" {
+		if !htmx {
+			a.renderErrorPage(w, http.StatusBadRequest, "Choose a destination folder")
+			return
+		}
+		data.Error = "Choose a destination folder"
+		a.renderFragment(w, "task.html", "task-destination", data)
 		return
 	}
-	http.Redirect(w, r, "/tasks", http.StatusFound)
+	err := a.App.Client.EditTask(r.Context(), session.SID, id, data.Destination)
+	a.tasksChanged(session)
+	if err != nil {
+		a.Logger.Error("task edit error", "error", err)
+		if !htmx || errors.Is(err, ErrSessionExpired) {
+			a.renderError(w, r, err)
+			return
+		}
+		_, data.Error = errorResponse(err)
+	}
+	if !htmx {
+		http.Redirect(w, r, "/tasks/"+url.PathEscape(id), http.StatusSeeOther)
+		return
+	}
+	data.Moved = err == nil
+	a.renderFragment(w, "task.html", "task-destination", data)
 }
 
-func (a *WebApp) deleteTask(w http.ResponseWriter, r *http.Request, sid string) {
+type taskFilesKey struct {
+	wanted   bool
+	priority string
+}
+
+// updateTaskFiles applies the files selection and priorities submitted. The
+// files with the same settings are changed with a single request.
+func (a *WebApp) updateTaskFiles(w http.ResponseWriter, r *http.Request, session *Session) {
 	id := r.PathValue("id")
-	err := a.App.Client.DeleteTask(r.Context(), sid, id)
+	if err := r.ParseForm(); err != nil {
+		a.renderErrorPage(w, http.StatusBadRequest, "Invalid files selection")
+		return
+	}
+	files, err := a.App.Client.ListTaskFiles(r.Context(), session.SID, id)
+	if err != nil {
+		a.Logger.Error("task files error", "error", err)
+		a.renderError(w, r, err)
+		return
+	}
+
+	var keys []taskFilesKey
+	changes := make(map[taskFilesKey][]int)
+	for _, file := range files {
+		index := strconv.Itoa(file.Index)
+		key := taskFilesKey{
+			wanted:   slices.Contains(r.Form["wanted"], index),
+			priority: r.FormValue("priority-" + index),
+		}
+		if !slices.Contains(FilePriorities, key.priority) {
+			key.priority = file.Priority
+		}
+		if key.wanted == file.Wanted && key.priority == file.Priority {
+			continue
+		}
+		if _, found := changes[key]; !found {
+			keys = append(keys, key)
+		}
+		changes[key] = append(changes[key], file.Index)
+	}
+
+	data := TaskFilesData{TaskId: id, Editable: true, Priorities: FilePriorities}
+	for _, key := range keys {
+		change := TaskFilesChange{Indexes: changes[key], Wanted: key.wanted, Priority: key.priority}
+		err = a.App.Client.SetTaskFiles(r.Context(), session.SID, id, change)
+		if errors.Is(err, ErrSessionExpired) {
+			a.renderError(w, r, err)
+			return
+		}
+		if err != nil {
+			a.Logger.Error("task files change error", "error", err)
+			_, data.Error = errorResponse(err)
+			break
+		}
+	}
+
+	if r.Header.Get("HX-Request") != "true" {
+		http.Redirect(w, r, "/tasks/"+url.PathEscape(id), http.StatusSeeOther)
+		return
+	}
+	data.Files, err = a.App.Client.ListTaskFiles(r.Context(), session.SID, id)
 	if err != nil {
-		a.Logger.Error("delete task error", "error", err)
-		a.renderError(w, err)
+		a.Logger.Error("task files error", "error", err)
+		a.renderError(w, r, err)
+		return
+	}
+	a.renderFragment(w, "task.html", "task-files", data)
+}
+
+type FoldersData struct {
+	Path    string
+	Parent  string
+	Folders []Folder
+}
+
+// folders renders the folder picker listing the directories inside the path
+// query parameter, the shared folders when it's missing.
+func (a *WebApp) folders(w http.ResponseWriter, r *http.Request, session *Session) {
+	folderPath := path.Clean("/" + r.URL.Query().Get("path"))
+	folders, err := a.App.Client.ListFolders(r.Context(), session.SID, folderPath)
+	if err != nil {
+		a.Logger.Error("folders error", "error", err)
+		a.renderError(w, r, err)
+		return
+	}
+	data := FoldersData{Path: folderPath, Folders: folders}
+	if folderPath != "/" {
+		data.Parent = path.Dir(folderPath)
+	}
+	a.renderFragment(w, "tasks.html", "folders", data)
+}
+
+// addFavourite saves the destination as a favourite of the user. htmx asks
+// for its name with a prompt, it defaults to the last folder of the path.
+func (a *WebApp) addFavourite(w http.ResponseWriter, r *http.Request, session *Session) {
+	destination := strings.Trim(r.FormValue("destination"), "/")
+	if destination == "" {
+		a.renderErrorPage(w, http.StatusBadRequest, "Choose a destination folder to save")
+		return
+	}
+	name := strings.TrimSpace(r.Header.Get("HX-Prompt"))
+	if name == "" {
+		name = strings.TrimSpace(r.FormValue("name"))
+	}
+	if name == "" {
+		name = path.Base(destination)
+	}
+	err := a.Favourites.Add(session.User, Favourite{Name: name, Path: destination})
+	if err != nil {
+		a.Logger.Error("saving favourite error", "error", err)
+		a.renderError(w, r, err)
+		return
+	}
+	a.renderFavourites(w, r, session)
+}
+
+func (a *WebApp) removeFavourite(w http.ResponseWriter, r *http.Request, session *Session) {
+	err := a.Favourites.Remove(session.User, r.FormValue("path"))
+	if err != nil {
+		a.Logger.Error("removing favourite error", "error", err)
+		a.renderError(w, r, err)
+		return
+	}
+	a.renderFavourites(w, r, session)
+}
+
+func (a *WebApp) renderFavourites(w http.ResponseWriter, r *http.Request, session *Session) {
+	if r.Header.Get("HX-Request") == "true" {
+		a.renderFragment(w, "tasks.html", "favourites", a.Favourites.List(session.User))
 		return
 	}
 	http.Redirect(w, r, "/tasks", http.StatusFound)
 }
 
-func (a *WebApp) pauseTask(w http.ResponseWriter, r *http.Request, sid string) {
+type SettingsPageData struct {
+	Tokens []APIToken
+
+	// NewToken is the token just created, shown only once.
+	NewToken string
+	Name     string
+	Error    string
+}
+
+func (a *WebApp) settings(w http.ResponseWriter, r *http.Request, session *Session) {
+	a.renderSettings(w, http.StatusOK, session, SettingsPageData{NewToken: a.Sessions.TakeFlash(session).NewToken})
+}
+
+func (a *WebApp) renderSettings(w http.ResponseWriter, status int, session *Session, data SettingsPageData) {
+	data.Tokens = a.Tokens.List(session.User)
+	w.Header().Add("Cache-Control", "no-cache, no-store, must-revalidate")
+	w.WriteHeader(status)
+	a.renderTemplate(w, "settings.html", data)
+}
+
+// newToken creates a personal API token. The password is checked by logging
+// in to DSM, 2-step verification accounts also need a code and the device
+// is trusted so the token can log in without one later. The browser is
+// redirected to the settings page showing the new token once.
+func (a *WebApp) newToken(w http.ResponseWriter, r *http.Request, session *Session) {
+	name := strings.TrimSpace(r.FormValue("name"))
+	data := SettingsPageData{Name: name}
+	if name == "" {
+		data.Error = "Give the token a name"
+		a.renderSettings(w, http.StatusUnprocessableEntity, session, data)
+		return
+	}
+	pass := r.FormValue("pass")
+	otpCode := strings.TrimSpace(r.FormValue("otp"))
+	response, err := a.App.Client.Login(r.Context(), LoginRequest{
+		user:        session.User,
+		pass:        pass,
+		otpCode:     otpCode,
+		trustDevice: otpCode != "",
+	})
+	if errors.Is(err, ErrOTPRequired) {
+		data.Error = "Your account uses 2-step verification, enter a code"
+		a.renderSettings(w, http.StatusUnprocessableEntity, session, data)
+		return
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		a.Logger.Warn("token login refused", "user", session.User, "error", err)
+		data.Error = apiErr.Message()
+		a.renderSettings(w, apiErrorStatus(apiErr), session, data)
+		return
+	}
+	if err != nil {
+		a.Logger.Error("token login error", "error", err)
+		a.renderError(w, r, err)
+		return
+	}
+	if err = a.App.Client.Logout(r.Context(), response.Data.SID); err != nil {
+		a.Logger.Warn("download station logout error", "error", err)
+	}
+
+	token, err := a.Tokens.Create(name, NASCredentials{User: session.User, Pass: pass, DeviceId: response.Data.DID})
+	if err != nil {
+		a.Logger.Error("creating token error", "error", err)
+		a.renderError(w, r, err)
+		return
+	}
+	a.Sessions.SetFlash(session, Flash{NewToken: token})
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
+
+// revokeToken deletes a token of the user and ends the DSM session it
+// opened.
+func (a *WebApp) revokeToken(w http.ResponseWriter, r *http.Request, session *Session) {
 	id := r.PathValue("id")
-	err := a.App.Client.PauseTask(r.Context(), sid, id)
+	revoked, err := a.Tokens.Revoke(session.User, id)
 	if err != nil {
-		a.Logger.Error("pause task error", "error", err)
-		a.renderError(w, err)
+		a.Logger.Error("revoking token error", "error", err)
+		a.renderError(w, r, err)
 		return
 	}
-	http.Redirect(w, r, "/tasks", http.StatusFound)
+	if revoked {
+		a.tokenLogins.Delete(id)
+		if tokenSession := a.forgetTokenSession(id); tokenSession != nil {
+			if err = a.App.Client.Logout(r.Context(), tokenSession.SID); err != nil {
+				a.Logger.Warn("download station logout error", "error", err)
+			}
+		}
+	}
+	if r.Header.Get("HX-Request") == "true" {
+		a.renderFragment(w, "settings.html", "tokens", a.Tokens.List(session.User))
+		return
+	}
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
+
+func (a *WebApp) deleteTask(w http.ResponseWriter, r *http.Request, session *Session) {
+	a.changeTask(w, r, session, "delete", func(id string) error {
+		return a.App.Client.DeleteTask(r.Context(), session.SID, id, deleteOptions(r))
+	})
 }
 
-func (a *WebApp) resumeTask(w http.ResponseWriter, r *http.Request, sid string) {
+// deleteOptions reads the choice made in the delete confirmation.
+func deleteOptions(r *http.Request) DeleteOptions {
+	return DeleteOptions{
+		ForceComplete: r.FormValue("force_complete") == "true",
+		RemoveFiles:   r.FormValue("remove_files") == "true",
+	}
+}
+
+func (a *WebApp) pauseTask(w http.ResponseWriter, r *http.Request, session *Session) {
+	a.changeTask(w, r, session, "pause", func(id string) error {
+		return a.App.Client.PauseTask(r.Context(), session.SID, id)
+	})
+}
+
+func (a *WebApp) resumeTask(w http.ResponseWriter, r *http.Request, session *Session) {
+	a.changeTask(w, r, session, "resume", func(id string) error {
+		return a.App.Client.ResumeTask(r.Context(), session.SID, id)
+	})
+}
+
+// changeTask applies a change to the task in the path. htmx requests get back
+// just the updated task card, or nothing when the task was deleted, and the
+// errors as a toast.
+func (a *WebApp) changeTask(w http.ResponseWriter, r *http.Request, session *Session, method string, change func(id string) error) {
 	id := r.PathValue("id")
-	err := a.App.Client.ResumeTask(r.Context(), sid, id)
+	err := change(id)
+	a.tasksChanged(session)
 	if err != nil {
-		a.Logger.Error("resume task error", "error", err)
-		a.renderError(w, err)
+		a.Logger.Error(method+" task error", "error", err)
+	}
+	if r.Header.Get("HX-Request") != "true" {
+		if err != nil {
+			a.renderError(w, r, err)
+			return
+		}
+		http.Redirect(w, r, "/tasks", http.StatusFound)
 		return
 	}
-	http.Redirect(w, r, "/tasks", http.StatusFound)
+	if err != nil {
+		a.renderErrorToast(w, r, fmt.Sprintf("Can't %s the task", method), err)
+		return
+	}
+	if method == "delete" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	task, err := a.App.Client.GetTask(r.Context(), session.SID, id)
+	if err != nil {
+		a.Logger.Error("task error", "error", err)
+		a.renderErrorToast(w, r, "Can't refresh the task", err)
+		return
+	}
+	a.renderFragment(w, "tasks.html", "task-card", TaskCardData{Task: *task})
+}
+
+// renderErrorToast answers an htmx request with the error shown as a toast
+// in the #toasts container of the page, keeping the error status. An
+// expired session still sends the browser to the login page.
+func (a *WebApp) renderErrorToast(w http.ResponseWriter, r *http.Request, title string, err error) {
+	if errors.Is(err, ErrSessionExpired) {
+		a.renderError(w, r, err)
+		return
+	}
+	status, message := errorResponse(err)
+	w.Header().Set("HX-Retarget", "#toasts")
+	w.Header().Set("HX-Reswap", "beforeend")
+	w.WriteHeader(status)
+	a.renderFragment(w, "error.html", "toast", ErrorPageData{Status: status, Message: title + ": " + message})
+}
+
+// tasksChanged is called after changing the tasks of the user so the next
+// list is fetched from Download Station and the live updates show the
+// change right away.
+func (a *WebApp) tasksChanged(session *Session) {
+	a.TasksCache.Invalidate(session.User)
+	a.TaskFeeds.Refresh(session.User)
 }
 
 func (a *WebApp) notFound(w http.ResponseWriter, r *http.Request) {
-	err := fmt.Errorf("Page %s not found", r.URL.Path)
 	w.Header().Add("Cache-Control", "no-cache, no-store, must-revalidate")
 	a.Logger.Warn("page not found", "url", r.URL.Path)
-	a.renderError(w, err)
+	a.renderErrorPage(w, http.StatusNotFound, fmt.Sprintf("Page %s not found", r.URL.Path))
 }
 
 func (a *WebApp) health(w http.ResponseWriter, _ *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
-func authenticated(handlerFunc SidHandlerFunc) http.HandlerFunc {
+func (a *WebApp) authenticated(handlerFunc SessionHandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		sidCookie, err := r.Cookie("sid")
-		if err != nil {
-			http.Redirect(w, r, "/login", http.StatusFound)
-			w.(http.Flusher).Flush()
+		session, found := a.session(r)
+		if !found {
+			redirectToLogin(w, r, false)
+			return
+		}
+		handlerFunc(w, r, session)
+	}
+}
+
+// authenticatedEvents is like authenticated for event streams. Without a
+// session they're answered with no content, EventSource stops reconnecting
+// then, while it would retry a redirect to the login page forever.
+func (a *WebApp) authenticatedEvents(handlerFunc SessionHandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, found := a.session(r)
+		if !found {
+			w.WriteHeader(http.StatusNoContent)
 			return
 		}
-		handlerFunc(w, r, sidCookie.Value)
+		handlerFunc(w, r, session)
+	}
+}
+
+func (a *WebApp) session(r *http.Request) (*Session, bool) {
+	cookie, err := r.Cookie(SessionCookieName)
+	if err != nil {
+		return nil, false
+	}
+	return a.Sessions.Get(cookie.Value)
+}
+
+// secureCookies tells whether the cookies are only sent back over HTTPS.
+// COOKIE_SECURE set to true or false decides, otherwise they're secure when
+// the request came over HTTPS, directly or through a proxy.
+func (a *WebApp) secureCookies(r *http.Request) bool {
+	switch a.App.Config.cookieSecure {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	return r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
+
+// endSession forgets the current session and removes its cookie.
+func (a *WebApp) endSession(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(SessionCookieName); err == nil {
+		a.Sessions.Delete(cookie.Value)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   a.secureCookies(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// redirectToLogin sends the browser to the login page remembering the page
+// the user was on. htmx requests get an HX-Redirect header instead of a 302,
+// otherwise htmx would follow the redirect and swap the login page into the
+// current one.
+func redirectToLogin(w http.ResponseWriter, r *http.Request, expired bool) {
+	query := url.Values{}
+	if next := returnPath(r); next != "/" {
+		query.Set("next", next)
+	}
+	if expired {
+		query.Set("expired", "1")
+	}
+	loginUrl := "/login"
+	if len(query) > 0 {
+		loginUrl += "?" + query.Encode()
+	}
+	if r.Header.Get("HX-Request") == "true" {
+		w.Header().Set("HX-Redirect", loginUrl)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	http.Redirect(w, r, loginUrl, http.StatusFound)
+}
+
+// returnPath is the page the user should land on after logging in again.
+// htmx calls report the page they were issued from, other non-GET requests
+// can't be replayed so they fall back to the home page.
+func returnPath(r *http.Request) string {
+	if r.Header.Get("HX-Request") == "true" {
+		current, err := url.Parse(r.Header.Get("HX-Current-URL"))
+		if err != nil || current.Path == "" {
+			return "/"
+		}
+		return safeRedirectPath(current.RequestURI())
+	}
+	if r.Method != http.MethodGet {
+		return "/"
+	}
+	return safeRedirectPath(r.URL.RequestURI())
+}
+
+// safeRedirectPath only lets through local absolute paths so the next
+// parameter can't be used as an open redirect.
+func safeRedirectPath(path string) string {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || strings.HasPrefix(path, "/\\") {
+		return "/"
 	}
+	return path
 }
 
 func LoadTemplates() TemplateCache {
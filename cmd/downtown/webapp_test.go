@@ -1,8 +1,20 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log/slog"
+	"mime/multipart"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 )
 
 type MockTransport func(*http.Request) *http.Response
@@ -26,3 +38,1079 @@ func TestHome(t *testing.T) {
 	//}
 	//a := NewWebApp(&app)
 }
+
+func TestTasksSessionExpired(t *testing.T) {
+	a, s := testWebApp(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"success":false,"error":{"code":119}}`))
+	})
+	defer s.Close()
+
+	req := httptest.NewRequest("GET", "/tasks?page=2", nil)
+	req.AddCookie(testSessionCookie(a))
+	rec := httptest.NewRecorder()
+	a.routes().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusFound {
+		t.Fatalf("status %d expected %d", rec.Code, http.StatusFound)
+	}
+	expected := "/login?expired=1&next=%2Ftasks%3Fpage%3D2"
+	if location := rec.Header().Get("Location"); location != expected {
+		t.Errorf("redirected to '%s' while '%s' expected", location, expected)
+	}
+}
+
+func TestTasksSessionExpiredHtmx(t *testing.T) {
+	a, s := testWebApp(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"success":false,"error":{"code":106}}`))
+	})
+	defer s.Close()
+
+	req := httptest.NewRequest("PUT", "/tasks/ID1/pause", nil)
+	req.AddCookie(testSessionCookie(a))
+	req.Header.Set("HX-Request", "true")
+	req.Header.Set("HX-Current-URL", "http://localhost:4000/tasks")
+	rec := httptest.NewRecorder()
+	a.routes().ServeHTTP(rec, req)
+
+	expected := "/login?expired=1&next=%2Ftasks"
+	if location := rec.Header().Get("HX-Redirect"); location != expected {
+		t.Errorf("htmx redirected to '%s' while '%s' expected", location, expected)
+	}
+}
+
+func TestTasksInvalidSid(t *testing.T) {
+	a, s := testWebApp(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"success":false,"error":{"code":105}}`))
+	})
+	defer s.Close()
+
+	cookie := testSessionCookie(a)
+	req := httptest.NewRequest("GET", "/tasks", nil)
+	req.AddCookie(cookie)
+	rec := httptest.NewRecorder()
+	a.routes().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusFound || !strings.HasPrefix(rec.Header().Get("Location"), "/login?expired=1") {
+		t.Fatalf("redirect to the login expected, got status %d to '%s'", rec.Code, rec.Header().Get("Location"))
+	}
+	if _, found := a.Sessions.Get(cookie.Value); found {
+		t.Error("session expected to be ended")
+	}
+}
+
+func TestLoginCreatesSession(t *testing.T) {
+	a, s := testWebApp(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"data":{"sid":"SIDDIS"},"success":true}`))
+	})
+	defer s.Close()
+
+	req := httptest.NewRequest("POST", "/login", strings.NewReader("user=user&pass=pass&next=%2Ftasks"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	a.routes().ServeHTTP(rec, req)
+
+	if location := rec.Header().Get("Location"); location != "/tasks" {
+		t.Errorf("redirected to '%s' while '/tasks' expected", location)
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != SessionCookieName {
+		t.Fatalf("session cookie expected, got %v", cookies)
+	}
+	cookie := cookies[0]
+	if cookie.Value == "SIDDIS" || !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode {
+		t.Errorf("session cookie not hardened: %v", cookie)
+	}
+	session, found := a.Sessions.Get(cookie.Value)
+	if !found {
+		t.Fatal("session not found in the store")
+	}
+	if session.SID != "SIDDIS" || session.User != "user" {
+		t.Errorf("session %+v doesn't carry the login sid and user", session)
+	}
+}
+
+func TestSecureCookies(t *testing.T) {
+	testCases := []struct {
+		config   string
+		proto    string
+		expected bool
+	}{
+		{"auto", "", false},
+		{"auto", "https", true},
+		{"true", "", true},
+		{"false", "https", false},
+	}
+
+	for _, tc := range testCases {
+		a := &WebApp{App: &App{Config: &AppConfig{cookieSecure: tc.config}}}
+		req := httptest.NewRequest("GET", "/", nil)
+		if tc.proto != "" {
+			req.Header.Set("X-Forwarded-Proto", tc.proto)
+		}
+		if result := a.secureCookies(req); result != tc.expected {
+			t.Errorf("secure %v with COOKIE_SECURE '%s' and proto '%s' while %v expected", result, tc.config, tc.proto, tc.expected)
+		}
+	}
+}
+
+func TestLogoutEndsDownloadStationSession(t *testing.T) {
+	loggedOut := false
+	a, s := testWebApp(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Get("method") == "logout" && query.Get("session") == "DownloadStation" && query.Get("_sid") == "SID" {
+			loggedOut = true
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"success":true}`))
+	})
+	defer s.Close()
+
+	cookie := testSessionCookie(a)
+	req := httptest.NewRequest("GET", "/logout", nil)
+	req.AddCookie(cookie)
+	rec := httptest.NewRecorder()
+	a.routes().ServeHTTP(rec, req)
+
+	if !loggedOut {
+		t.Error("download station logout not called with the session sid")
+	}
+	if _, found := a.Sessions.Get(cookie.Value); found {
+		t.Error("session still in the store after logout")
+	}
+	if location := rec.Header().Get("Location"); location != "/" {
+		t.Errorf("redirected to '%s' while '/' expected", location)
+	}
+}
+
+func TestLoginOTPStep(t *testing.T) {
+	a, s := testWebApp(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		if r.FormValue("account") != "user" || r.FormValue("passwd") != "pass" {
+			t.Errorf("credentials '%s' '%s' sent while 'user' 'pass' expected", r.FormValue("account"), r.FormValue("passwd"))
+		}
+		if r.FormValue("otp_code") == "" {
+			_, _ = w.Write([]byte(`{"error":{"code":403},"success":false}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":{"did":"DIDDID","sid":"SIDDIS"},"success":true}`))
+	})
+	defer s.Close()
+
+	req := httptest.NewRequest("POST", "/login", strings.NewReader("user=user&pass=pass"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	a.routes().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `name="otp"`) {
+		t.Fatalf("verification code field expected in the login page, got status %d", rec.Code)
+	}
+	if strings.Contains(rec.Body.String(), `name="pass"`) {
+		t.Error("password not expected in the verification code step")
+	}
+	match := regexp.MustCompile(`name="login" value="([^"]+)"`).FindStringSubmatch(rec.Body.String())
+	if match == nil {
+		t.Fatal("pending login token expected in the verification code step")
+	}
+
+	req = httptest.NewRequest("POST", "/login", strings.NewReader("login="+match[1]+"&otp=123456&trust=on"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec = httptest.NewRecorder()
+	a.routes().ServeHTTP(rec, req)
+
+	var deviceCookie *http.Cookie
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == DeviceCookieName {
+			deviceCookie = cookie
+		}
+	}
+	if deviceCookie == nil || deviceCookie.Value != "DIDDID" {
+		t.Errorf("device token cookie with value 'DIDDID' expected, got %v", deviceCookie)
+	}
+}
+
+func TestLoginOTPStepExpired(t *testing.T) {
+	a, s := testWebApp(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("no login expected with an unknown pending login")
+	})
+	defer s.Close()
+
+	req := httptest.NewRequest("POST", "/login", strings.NewReader("login=unknown&otp=123456"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	a.routes().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusUnauthorized || !strings.Contains(rec.Body.String(), `name="pass"`) {
+		t.Errorf("login form expected again, got status %d", rec.Code)
+	}
+}
+
+func TestLoginWrongPassword(t *testing.T) {
+	a, s := testWebApp(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"error":{"code":400},"success":false}`))
+	})
+	defer s.Close()
+
+	req := httptest.NewRequest("POST", "/login", strings.NewReader("user=user&pass=wrong"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	a.routes().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("status %d expected %d", rec.Code, http.StatusUnauthorized)
+	}
+	if !strings.Contains(rec.Body.String(), "No such account or incorrect password") {
+		t.Error("login page expected to show the authentication error")
+	}
+}
+
+func TestTaskErrorStatus(t *testing.T) {
+	a, s := testWebApp(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"error":{"code":404},"success":false}`))
+	})
+	defer s.Close()
+
+	req := httptest.NewRequest("PUT", "/tasks/ID1/pause", nil)
+	req.AddCookie(testSessionCookie(a))
+	rec := httptest.NewRecorder()
+	a.routes().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status %d expected %d", rec.Code, http.StatusNotFound)
+	}
+	if !strings.Contains(rec.Body.String(), "Invalid task id") {
+		t.Error("error page expected to show the task error message")
+	}
+}
+
+func TestNewTaskUploadTooLarge(t *testing.T) {
+	a, s := testWebApp(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("no request expected for a file over the size limit")
+	})
+	defer s.Close()
+
+	body := new(bytes.Buffer)
+	form := multipart.NewWriter(body)
+	part, _ := form.CreateFormFile("file", "big.torrent")
+	_, _ = part.Write(bytes.Repeat([]byte("d"), MaxUploadSize+128*KB))
+	_ = form.Close()
+
+	req := httptest.NewRequest("POST", "/tasks", body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.Header.Set("HX-Request", "true")
+	req.AddCookie(testSessionCookie(a))
+	rec := httptest.NewRecorder()
+	a.routes().ServeHTTP(rec, req)
+
+	if !strings.Contains(rec.Body.String(), "Files can&#39;t be larger than 10.00MB") {
+		t.Errorf("size limit error expected in the results, got '%s'", rec.Body.String())
+	}
+}
+
+func TestNewTaskUploadJustOverLimit(t *testing.T) {
+	a, s := testWebApp(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("no request expected for a file over the size limit")
+	})
+	defer s.Close()
+
+	body := new(bytes.Buffer)
+	form := multipart.NewWriter(body)
+	part, _ := form.CreateFormFile("file", "big.torrent")
+	_, _ = part.Write(bytes.Repeat([]byte("d"), MaxUploadSize+32*KB))
+	_ = form.Close()
+
+	req := httptest.NewRequest("POST", "/tasks", body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.Header.Set("HX-Request", "true")
+	req.AddCookie(testSessionCookie(a))
+	rec := httptest.NewRecorder()
+	a.routes().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status %d expected %d", rec.Code, http.StatusRequestEntityTooLarge)
+	}
+	if !strings.Contains(rec.Body.String(), "Files can&#39;t be larger than 10.00MB") {
+		t.Errorf("size limit error expected in the results, got '%s'", rec.Body.String())
+	}
+}
+
+func TestNewTaskLinks(t *testing.T) {
+	var uris []string
+	a, s := testWebApp(func(w http.ResponseWriter, r *http.Request) {
+		uris = append(uris, r.FormValue("uri"))
+		if r.FormValue("destination") != "video/Movies" {
+			t.Errorf("destination '%s' sent while 'video/Movies' expected", r.FormValue("destination"))
+		}
+		w.WriteHeader(http.StatusOK)
+		if strings.Contains(r.FormValue("uri"), "magnet:") {
+			_, _ = w.Write([]byte(`{"error":{"code":401},"success":false}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"success":true}`))
+	})
+	defer s.Close()
+
+	form := url.Values{
+		"urls":        {"https://example.com/a.iso\nmagnet:?xt=urn:btih:ABC\nnot a link"},
+		"destination": {"/video/Movies/"},
+	}
+	req := httptest.NewRequest("POST", "/tasks", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("HX-Request", "true")
+	req.AddCookie(testSessionCookie(a))
+	rec := httptest.NewRecorder()
+	a.routes().ServeHTTP(rec, req)
+
+	body := rec.Body.String()
+	for _, expected := range []string{"Added https://example.com/a.iso", "magnet:?xt=urn:btih:ABC: Max number of tasks reached", "not a link: Not a supported link"} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("result '%s' expected in '%s'", expected, body)
+		}
+	}
+	if strings.Contains(body, "<html") {
+		t.Error("only the results expected for htmx requests")
+	}
+	expected := []string{"https://example.com/a.iso,magnet:?xt=urn:btih:ABC", "https://example.com/a.iso", "magnet:?xt=urn:btih:ABC"}
+	if !slices.Equal(uris, expected) {
+		t.Errorf("uris %v sent while %v expected, the links one by one after the list failed", uris, expected)
+	}
+}
+
+func TestNewTaskLinksRedirect(t *testing.T) {
+	a, s := testWebApp(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		if r.FormValue("method") == "list" {
+			_, _ = w.Write([]byte(`{"data":{"offset":0,"tasks":[],"total":0},"success":true}`))
+			return
+		}
+		if r.FormValue("uri") != "https://example.com/a.iso,https://example.com/b.iso" {
+			t.Errorf("uri list '%s' sent while both links expected in one request", r.FormValue("uri"))
+		}
+		_, _ = w.Write([]byte(`{"success":true}`))
+	})
+	defer s.Close()
+	cookie := testSessionCookie(a)
+
+	req := httptest.NewRequest("POST", "/tasks", strings.NewReader("urls=https://example.com/a.iso%0Ahttps://example.com/b.iso"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(cookie)
+	rec := httptest.NewRecorder()
+	a.routes().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/tasks" {
+		t.Fatalf("redirect to /tasks expected, got status %d to '%s'", rec.Code, rec.Header().Get("Location"))
+	}
+	for _, expected := range []bool{true, false} {
+		req = httptest.NewRequest("GET", "/tasks", nil)
+		req.AddCookie(cookie)
+		rec = httptest.NewRecorder()
+		a.routes().ServeHTTP(rec, req)
+		if strings.Contains(rec.Body.String(), "Added https://example.com/a.iso") != expected {
+			t.Errorf("result shown %v while %v expected", !expected, expected)
+		}
+	}
+}
+
+func TestSafeRedirectPath(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected string
+	}{
+		{"", "/"},
+		{"/tasks", "/tasks"},
+		{"/tasks?page=2", "/tasks?page=2"},
+		{"https://evil.example", "/"},
+		{"//evil.example", "/"},
+		{"/\\evil.example", "/"},
+	}
+
+	for _, tc := range testCases {
+		result := safeRedirectPath(tc.input)
+		if result != tc.expected {
+			t.Errorf("safeRedirectPath(%q) = %q; want %q", tc.input, result, tc.expected)
+		}
+	}
+}
+
+func testWebApp(f http.HandlerFunc) (*WebApp, *httptest.Server) {
+	c, s := testClient(f)
+	cache := NewTasksCache(c, 0)
+	return &WebApp{
+		App:        &App{Config: &AppConfig{}, Client: c, Logger: slog.Default()},
+		Logger:     slog.Default(),
+		Templates:  LoadTemplates(),
+		Sessions:   NewSessionStore(nil, time.Hour, time.Hour),
+		Favourites: &FavouritesStore{favourites: make(map[string][]Favourite)},
+		Tokens:     &TokenStore{key: make([]byte, 32), tokens: make(map[string]*APIToken), now: time.Now},
+		TasksCache: cache,
+		TaskFeeds:  NewTaskFeeds(cache, 10*time.Millisecond, slog.Default()),
+	}, s
+}
+
+func testSessionCookie(a *WebApp) *http.Cookie {
+	_, token := a.Sessions.Create("user", "SID")
+	return &http.Cookie{Name: SessionCookieName, Value: token}
+}
+
+func TestFolders(t *testing.T) {
+	a, s := testWebApp(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("folder_path") != "/video" {
+			t.Errorf("folder '%s' listed while /video expected", r.FormValue("folder_path"))
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"data":{"files":[{"isdir":true,"name":"Movies","path":"/video/Movies"}]},"success":true}`))
+	})
+	defer s.Close()
+
+	req := httptest.NewRequest("GET", "/folders?path=video/", nil)
+	req.Header.Set("HX-Request", "true")
+	req.AddCookie(testSessionCookie(a))
+	rec := httptest.NewRecorder()
+	a.routes().ServeHTTP(rec, req)
+
+	body := rec.Body.String()
+	for _, expected := range []string{`data-destination="video/Movies"`, `/folders?path=%2Fvideo%2FMovies`, `/folders?path=%2F"`} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("'%s' expected in '%s'", expected, body)
+		}
+	}
+}
+
+func TestAddFavourite(t *testing.T) {
+	a, s := testWebApp(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("no request to Download Station expected")
+	})
+	defer s.Close()
+	favourites, err := NewFavouritesStore(filepath.Join(t.TempDir(), "favourites.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Favourites = favourites
+
+	form := url.Values{"destination": {"/video/Movies"}}
+	req := httptest.NewRequest("POST", "/favourites", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("HX-Request", "true")
+	req.Header.Set("HX-Prompt", "Films")
+	req.AddCookie(testSessionCookie(a))
+	rec := httptest.NewRecorder()
+	a.routes().ServeHTTP(rec, req)
+
+	expected := []Favourite{{Name: "Films", Path: "video/Movies"}}
+	if saved := favourites.List("user"); !slices.Equal(saved, expected) {
+		t.Errorf("favourites %v saved while %v expected", saved, expected)
+	}
+	if body := rec.Body.String(); !strings.Contains(body, "Films") || strings.Contains(body, "<html") {
+		t.Errorf("favourites fragment expected, got '%s'", body)
+	}
+}
+
+func TestTaskPage(t *testing.T) {
+	a, s := testWebApp(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"data":{"tasks":[{"additional":{"detail":{"destination":"downloads","uri":"magnet:?xt=urn:btih:ABC"},"peer":[{"address":"203.0.113.7","agent":"qBittorrent","progress":0.25}],"tracker":[{"url":"udp://tracker.example.org:6969","status":"Success"}]},"id":"ID1","size":100,"status":"downloading","title":"a.iso","type":"bt"}]},"success":true}`))
+	})
+	defer s.Close()
+
+	req := httptest.NewRequest("GET", "/tasks/ID1", nil)
+	req.AddCookie(testSessionCookie(a))
+	rec := httptest.NewRecorder()
+	a.routes().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d expected %d", rec.Code, http.StatusOK)
+	}
+	body := rec.Body.String()
+	for _, expected := range []string{"a.iso", "downloads", "magnet:?xt=urn:btih:ABC", "qBittorrent", "25.0", "udp://tracker.example.org:6969"} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("'%s' expected in the task page", expected)
+		}
+	}
+}
+
+func TestTaskStatus(t *testing.T) {
+	a, s := testWebApp(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("method") != "getinfo" {
+			t.Errorf("only the task info expected, %s %s called", r.FormValue("api"), r.FormValue("method"))
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"data":{"tasks":[{"id":"ID1","size":100,"status":"downloading","title":"a.iso","type":"bt"}]},"success":true}`))
+	})
+	defer s.Close()
+
+	req := httptest.NewRequest("GET", "/tasks/ID1/status", nil)
+	req.AddCookie(testSessionCookie(a))
+	rec := httptest.NewRecorder()
+	a.routes().ServeHTTP(rec, req)
+
+	body := rec.Body.String()
+	if !strings.Contains(body, `<div id="task" hx-get="/tasks/ID1/status"`) || !strings.Contains(body, "Status downloading") {
+		t.Errorf("task status fragment expected, got '%s'", body)
+	}
+	if strings.Contains(body, "<html") {
+		t.Error("only the status fragment expected")
+	}
+}
+
+func TestUpdateTaskFiles(t *testing.T) {
+	var changes []string
+	c, s := ds2Client(t, func(r *http.Request) {
+		if r.FormValue("method") == "set" {
+			changes = append(changes, r.FormValue("index")+" "+r.FormValue("wanted")+" "+r.FormValue("priority"))
+		}
+	})
+	defer s.Close()
+	a, ts := testWebApp(nil)
+	defer ts.Close()
+	a.App.Client = c
+
+	// README.txt is skipped, sample.iso is skipped and set to low, the iso
+	// is unchanged
+	form := url.Values{"wanted": {"0"}, "priority-0": {"normal"}, "priority-1": {"normal"}, "priority-2": {"low"}}
+	req := httptest.NewRequest("PUT", "/tasks/dbid_101/files", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("HX-Request", "true")
+	req.AddCookie(testSessionCookie(a))
+	rec := httptest.NewRecorder()
+	a.routes().ServeHTTP(rec, req)
+
+	expected := []string{`[1] false "normal"`, `[2] false "low"`}
+	if !slices.Equal(changes, expected) {
+		t.Errorf("changes %v sent while %v expected", changes, expected)
+	}
+	if body := rec.Body.String(); !strings.Contains(body, `id="task-files"`) || strings.Contains(body, "<html") {
+		t.Errorf("task files fragment expected, got '%s'", body)
+	}
+}
+
+func TestMoveTask(t *testing.T) {
+	a, s := testWebApp(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		if r.FormValue("destination") == "readonly" {
+			_, _ = w.Write([]byte(`{"success":false,"error":{"code":402}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":[{"error":0,"id":"ID1"}],"success":true}`))
+	})
+	defer s.Close()
+
+	testCases := []struct {
+		destination string
+		expected    string
+	}{
+		{"/video/Movies/", "Moved to video/Movies"},
+		{"readonly", "Destination denied"},
+		{"", "Choose a destination folder"},
+	}
+	for _, tc := range testCases {
+		form := url.Values{"destination": {tc.destination}}
+		req := httptest.NewRequest("PUT", "/tasks/ID1/destination", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("HX-Request", "true")
+		req.AddCookie(testSessionCookie(a))
+		rec := httptest.NewRecorder()
+		a.routes().ServeHTTP(rec, req)
+
+		if body := rec.Body.String(); !strings.Contains(body, tc.expected) || strings.Contains(body, "<html") {
+			t.Errorf("destination form with '%s' expected moving to '%s', got '%s'", tc.expected, tc.destination, body)
+		}
+	}
+}
+
+func TestPauseAllTasks(t *testing.T) {
+	a, s := testWebApp(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		switch r.FormValue("method") {
+		case "list":
+			_, _ = w.Write([]byte(`{"data":{"tasks":[
+				{"id":"ID1","status":"downloading","title":"a.iso"},
+				{"id":"ID2","status":"seeding","title":"b.iso"},
+				{"id":"ID3","status":"paused","title":"c.iso"}
+			]},"success":true}`))
+		case "pause":
+			if r.FormValue("id") != "ID1,ID2" {
+				t.Errorf("paused '%s' while 'ID1,ID2' expected", r.FormValue("id"))
+			}
+			_, _ = w.Write([]byte(`{"data":[{"id":"ID1","error":0},{"id":"ID2","error":405}],"success":true}`))
+		}
+	})
+	defer s.Close()
+
+	form := url.Values{"scope": {"all"}}
+	req := httptest.NewRequest("PUT", "/tasks/pause", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("HX-Request", "true")
+	req.AddCookie(testSessionCookie(a))
+	rec := httptest.NewRecorder()
+	a.routes().ServeHTTP(rec, req)
+
+	if trigger := rec.Header().Get("HX-Trigger"); trigger != "" {
+		t.Errorf("HX-Trigger '%s' while the failed task card should be kept", trigger)
+	}
+	body := rec.Body.String()
+	for _, expected := range []string{"Paused 1 of 2 tasks", `id="task-ID2" class="grid task task-seeding" hx-swap-oob="true"`, "Invalid task action"} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("'%s' expected in '%s'", expected, body)
+		}
+	}
+}
+
+func TestPauseSelectedTasks(t *testing.T) {
+	a, s := testWebApp(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("method") != "pause" {
+			t.Errorf("only the pause method expected, %s called", r.FormValue("method"))
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"data":[{"id":"ID1","error":0},{"id":"ID2","error":0}],"success":true}`))
+	})
+	defer s.Close()
+
+	form := url.Values{"ids": {"ID1", "ID2"}}
+	req := httptest.NewRequest("PUT", "/tasks/pause", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("HX-Request", "true")
+	req.AddCookie(testSessionCookie(a))
+	rec := httptest.NewRecorder()
+	a.routes().ServeHTTP(rec, req)
+
+	if !strings.Contains(rec.Body.String(), "Paused 2 of 2 tasks") {
+		t.Errorf("'Paused 2 of 2 tasks' expected in '%s'", rec.Body.String())
+	}
+}
+
+func TestPauseSelectedTasksNoResults(t *testing.T) {
+	a, s := testWebApp(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"success":true}`))
+	})
+	defer s.Close()
+
+	form := url.Values{"ids": {"ID1", "ID2"}}
+	req := httptest.NewRequest("PUT", "/tasks/pause", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("HX-Request", "true")
+	req.AddCookie(testSessionCookie(a))
+	rec := httptest.NewRecorder()
+	a.routes().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("status %d expected %d", rec.Code, http.StatusBadGateway)
+	}
+	if !strings.Contains(rec.Body.String(), "didn&#39;t report the outcome") {
+		t.Errorf("missing results error expected in '%s'", rec.Body.String())
+	}
+}
+
+func TestDeleteTaskOptions(t *testing.T) {
+	a, s := testWebApp(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("method") == "delete" && r.FormValue("force_complete") != "true" {
+			t.Errorf("force_complete '%s' sent while 'true' expected", r.FormValue("force_complete"))
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"data":[{"id":"ID1","error":0}],"success":true}`))
+	})
+	defer s.Close()
+
+	req := httptest.NewRequest("DELETE", "/tasks/ID1?force_complete=true", nil)
+	req.Header.Set("HX-Request", "true")
+	req.AddCookie(testSessionCookie(a))
+	rec := httptest.NewRecorder()
+	a.routes().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK || rec.Body.Len() != 0 {
+		t.Errorf("empty response with status %d expected so that htmx removes the card, got %d '%s'", http.StatusOK, rec.Code, rec.Body.String())
+	}
+}
+
+func TestPauseTaskHtmx(t *testing.T) {
+	a, s := testWebApp(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		switch r.FormValue("method") {
+		case "pause":
+			_, _ = w.Write([]byte(`{"data":[{"id":"ID1","error":0}],"success":true}`))
+		case "getinfo":
+			_, _ = w.Write([]byte(`{"data":{"tasks":[{"id":"ID1","status":"paused","title":"a.iso"}]},"success":true}`))
+		}
+	})
+	defer s.Close()
+
+	req := httptest.NewRequest("PUT", "/tasks/ID1/pause", nil)
+	req.Header.Set("HX-Request", "true")
+	req.AddCookie(testSessionCookie(a))
+	rec := httptest.NewRecorder()
+	a.routes().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d expected %d", rec.Code, http.StatusOK)
+	}
+	body := rec.Body.String()
+	if !strings.Contains(body, `id="task-ID1"`) || !strings.Contains(body, `hx-put="/tasks/ID1/resume"`) {
+		t.Errorf("paused task card expected, got '%s'", body)
+	}
+	if strings.Contains(body, "<html") {
+		t.Error("whole page rendered instead of the task card")
+	}
+}
+
+func TestPauseTaskFailedHtmx(t *testing.T) {
+	a, s := testWebApp(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		switch r.FormValue("method") {
+		case "pause":
+			_, _ = w.Write([]byte(`{"data":[{"id":"ID1","error":405}],"success":true}`))
+		case "getinfo":
+			_, _ = w.Write([]byte(`{"data":{"tasks":[{"id":"ID1","status":"finished","title":"a.iso"}]},"success":true}`))
+		}
+	})
+	defer s.Close()
+
+	req := httptest.NewRequest("PUT", "/tasks/ID1/pause", nil)
+	req.Header.Set("HX-Request", "true")
+	req.AddCookie(testSessionCookie(a))
+	rec := httptest.NewRecorder()
+	a.routes().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusConflict {
+		t.Errorf("status %d expected %d", rec.Code, http.StatusConflict)
+	}
+	if target := rec.Header().Get("HX-Retarget"); target != "#toasts" {
+		t.Errorf("error retargeted to '%s' while '#toasts' expected", target)
+	}
+	body := rec.Body.String()
+	if !strings.Contains(body, `role="alert"`) || !strings.Contains(body, "Invalid task action") {
+		t.Errorf("error toast expected, got '%s'", body)
+	}
+	if strings.Contains(body, "<html") || strings.Contains(body, `id="task-ID1"`) {
+		t.Error("only the toast expected in the response")
+	}
+}
+
+func TestSettingsNewToken(t *testing.T) {
+	loggedOut := false
+	a, s := testWebApp(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		switch r.FormValue("method") {
+		case "login":
+			if r.FormValue("account") != "user" || r.FormValue("passwd") != "pass" || r.FormValue("enable_device_token") != "yes" {
+				t.Errorf("login of the session user trusting the device expected, got %v", r.Form)
+			}
+			_, _ = w.Write([]byte(`{"data":{"sid":"CHECKSID","did":"DID"},"success":true}`))
+		case "logout":
+			loggedOut = r.FormValue("_sid") == "CHECKSID"
+			_, _ = w.Write([]byte(`{"success":true}`))
+		}
+	})
+	defer s.Close()
+	a.Tokens, _ = testTokenStore(t)
+
+	cookie := testSessionCookie(a)
+	req := httptest.NewRequest("POST", "/settings/tokens", strings.NewReader("name=backup&pass=pass&otp=123456"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(cookie)
+	rec := httptest.NewRecorder()
+	a.routes().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/settings" {
+		t.Fatalf("redirect to /settings expected, got status %d to '%s'", rec.Code, rec.Header().Get("Location"))
+	}
+	if !loggedOut {
+		t.Error("session opened to check the password not logged out")
+	}
+	tokens := a.Tokens.List("user")
+	if len(tokens) != 1 || tokens[0].Name != "backup" {
+		t.Fatalf("token 'backup' expected, got %+v", tokens)
+	}
+
+	req = httptest.NewRequest("GET", "/settings", nil)
+	req.AddCookie(cookie)
+	rec = httptest.NewRecorder()
+	a.routes().ServeHTTP(rec, req)
+	body := rec.Body.String()
+	start := strings.Index(body, APITokenPrefix)
+	if start < 0 {
+		t.Fatal("new token not shown")
+	}
+	token := body[start : start+strings.Index(body[start:], "<")]
+	if _, credentials, ok := a.Tokens.Verify(token); !ok || credentials.DeviceId != "DID" {
+		t.Errorf("shown token not verified with the trusted device: %+v", credentials)
+	}
+
+	req = httptest.NewRequest("GET", "/settings", nil)
+	req.AddCookie(cookie)
+	rec = httptest.NewRecorder()
+	a.routes().ServeHTTP(rec, req)
+	if strings.Contains(rec.Body.String(), token) {
+		t.Error("new token shown more than once")
+	}
+
+	req = httptest.NewRequest("DELETE", "/settings/tokens/"+tokens[0].ID, nil)
+	req.Header.Set("HX-Request", "true")
+	req.AddCookie(cookie)
+	rec = httptest.NewRecorder()
+	a.routes().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "No API tokens yet") {
+		t.Errorf("tokens fragment without tokens expected, got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestSettingsNewTokenWrongPassword(t *testing.T) {
+	a, s := testWebApp(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"error":{"code":400},"success":false}`))
+	})
+	defer s.Close()
+
+	req := httptest.NewRequest("POST", "/settings/tokens", strings.NewReader("name=backup&pass=wrong"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(testSessionCookie(a))
+	rec := httptest.NewRecorder()
+	a.routes().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("status %d expected %d", rec.Code, http.StatusUnauthorized)
+	}
+	if tokens := a.Tokens.List("user"); len(tokens) != 0 {
+		t.Errorf("no token expected, got %+v", tokens)
+	}
+}
+
+func TestTaskEventsWithoutSession(t *testing.T) {
+	a, s := testWebApp(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("no Download Station call expected without a session")
+	})
+	defer s.Close()
+
+	req := httptest.NewRequest("GET", "/tasks/events", nil)
+	rec := httptest.NewRecorder()
+	a.routes().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("status %d expected %d", rec.Code, http.StatusNoContent)
+	}
+}
+
+func TestTaskEvents(t *testing.T) {
+	a, s := testWebApp(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"data":{"offset":0,"tasks":[{"id":"ID1","status":"downloading","title":"a.iso"}],"total":1},"success":true}`))
+	})
+	defer s.Close()
+	server := httptest.NewServer(a.routes())
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL+"/tasks/events", nil)
+	req.AddCookie(testSessionCookie(a))
+	res, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+	if contentType := res.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Fatalf("event stream expected, got %s", contentType)
+	}
+
+	var event []string
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() && scanner.Text() != "" {
+		event = append(event, scanner.Text())
+	}
+	if len(event) == 0 || event[0] != "event: tasks" {
+		t.Fatalf("tasks event expected, got %v", event)
+	}
+	data := strings.Join(event[1:], "\n")
+	if !strings.Contains(data, `id="tasks" hx-swap-oob="innerHTML"`) || !strings.Contains(data, `id="task-ID1"`) {
+		t.Errorf("first event doesn't replace the tasks list: %s", data)
+	}
+}
+
+func TestTaskEventsSessionEnded(t *testing.T) {
+	a, s := testWebApp(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"data":{"offset":0,"tasks":[{"id":"ID1","status":"downloading","title":"a.iso"}],"total":1},"success":true}`))
+	})
+	defer s.Close()
+	a.EventsKeepAlive = 10 * time.Millisecond
+	server := httptest.NewServer(a.routes())
+	defer server.Close()
+
+	cookie := testSessionCookie(a)
+	req, _ := http.NewRequest("GET", server.URL+"/tasks/events", nil)
+	req.AddCookie(cookie)
+	res, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+
+	scanner := bufio.NewScanner(res.Body)
+	keepAlive := false
+	for scanner.Scan() && !keepAlive {
+		keepAlive = scanner.Text() == ": keepalive"
+	}
+	if !keepAlive {
+		t.Fatal("keepalive comment expected on an idle stream")
+	}
+
+	a.Sessions.Delete(cookie.Value)
+	var lines []string
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if !slices.Contains(lines, "event: expired") {
+		t.Errorf("expired event expected after the session ended, got %v", lines)
+	}
+}
+
+func TestTaskChangeInvalidatesTasksCache(t *testing.T) {
+	lists := 0
+	a, s := testWebApp(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		if r.FormValue("method") == "list" {
+			lists++
+			_, _ = w.Write([]byte(`{"data":{"offset":0,"tasks":[],"total":0},"success":true}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":[{"error":0,"id":"ID1"}],"success":true}`))
+	})
+	defer s.Close()
+	a.TasksCache.ttl = time.Minute
+	cookie := testSessionCookie(a)
+
+	for _, request := range []struct{ method, path string }{
+		{"GET", "/tasks"},
+		{"GET", "/tasks"},
+		{"PUT", "/tasks/ID1/pause"},
+		{"GET", "/tasks"},
+	} {
+		req := httptest.NewRequest(request.method, request.path, nil)
+		req.AddCookie(cookie)
+		a.routes().ServeHTTP(httptest.NewRecorder(), req)
+	}
+	if lists != 2 {
+		t.Errorf("2 tasks lists expected, the second after the pause, got %d", lists)
+	}
+}
+
+func TestTasksFilterCookie(t *testing.T) {
+	a, s := testWebApp(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"data":{"offset":0,"tasks":[{"id":"ID1","status":"downloading","title":"a.iso"},{"id":"ID2","status":"paused","title":"b.iso"}],"total":2},"success":true}`))
+	})
+	defer s.Close()
+	session := testSessionCookie(a)
+
+	req := httptest.NewRequest("GET", "/tasks?status=paused", nil)
+	req.AddCookie(session)
+	rec := httptest.NewRecorder()
+	a.routes().ServeHTTP(rec, req)
+
+	var filterCookie *http.Cookie
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == TaskFilterCookieName {
+			filterCookie = cookie
+		}
+	}
+	if filterCookie == nil || filterCookie.Value != "status=paused" {
+		t.Fatalf("filter cookie expected, got %v", filterCookie)
+	}
+	if body := rec.Body.String(); strings.Contains(body, `id="task-ID1"`) || !strings.Contains(body, `id="task-ID2"`) {
+		t.Error("only the paused task expected")
+	}
+
+	req = httptest.NewRequest("GET", "/tasks", nil)
+	req.AddCookie(session)
+	req.AddCookie(filterCookie)
+	rec = httptest.NewRecorder()
+	a.routes().ServeHTTP(rec, req)
+
+	body := rec.Body.String()
+	if strings.Contains(body, `id="task-ID1"`) || !strings.Contains(body, `id="task-ID2"`) {
+		t.Error("filter of the cookie not applied")
+	}
+	if !strings.Contains(body, `sse-connect="/tasks/events?status=paused"`) {
+		t.Error("live updates not filtered like the list")
+	}
+}
+
+func TestSameTaskOrder(t *testing.T) {
+	previous := []Task{{Id: "ID1"}, {Id: "ID2"}, {Id: "ID3"}}
+	if !sameTaskOrder(previous, []Task{{Id: "ID1"}, {Id: "ID3"}, {Id: "ID4"}}) {
+		t.Error("removing and adding tasks doesn't change the order")
+	}
+	if sameTaskOrder(previous, []Task{{Id: "ID2"}, {Id: "ID1"}, {Id: "ID3"}}) {
+		t.Error("swapped tasks not detected")
+	}
+}
+
+func testTasksJSON(count int, status func(i int) string) string {
+	var tasks []string
+	for i := range count {
+		tasks = append(tasks, fmt.Sprintf(`{"id":"ID%d","status":"%s","title":"task %03d"}`, i, status(i), i))
+	}
+	return strings.Join(tasks, ",")
+}
+
+func TestTasksPagination(t *testing.T) {
+	a, s := testWebApp(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("offset") != "50" || r.FormValue("limit") != "50" {
+			t.Errorf("second page of 50 tasks expected, got offset '%s' and limit '%s'", r.FormValue("offset"), r.FormValue("limit"))
+		}
+		w.WriteHeader(http.StatusOK)
+		tasks := testTasksJSON(10, func(int) string { return "finished" })
+		_, _ = w.Write([]byte(`{"data":{"offset":50,"tasks":[` + tasks + `],"total":60},"success":true}`))
+	})
+	defer s.Close()
+
+	req := httptest.NewRequest("GET", "/tasks?page=2", nil)
+	req.AddCookie(testSessionCookie(a))
+	rec := httptest.NewRecorder()
+	a.routes().ServeHTTP(rec, req)
+
+	body := rec.Body.String()
+	for _, expected := range []string{"51-60 of 60", "Page 2 of 2", `href="/tasks?"`, `sse-connect="/tasks/events?page=2"`, `id="tasks" hx-get="/tasks?page=2"`} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("'%s' expected in the page", expected)
+		}
+	}
+	if strings.Contains(body, ">Next<") {
+		t.Error("no next page expected")
+	}
+}
+
+func TestTasksPaginationFiltered(t *testing.T) {
+	a, s := testWebApp(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Has("limit") {
+			t.Error("all the tasks expected to filter them")
+		}
+		w.WriteHeader(http.StatusOK)
+		tasks := testTasksJSON(130, func(i int) string { return []string{"paused", "finished"}[i%2] })
+		_, _ = w.Write([]byte(`{"data":{"offset":0,"tasks":[` + tasks + `],"total":130},"success":true}`))
+	})
+	defer s.Close()
+
+	req := httptest.NewRequest("GET", "/tasks?status=paused&page=9", nil)
+	req.AddCookie(testSessionCookie(a))
+	rec := httptest.NewRecorder()
+	a.routes().ServeHTTP(rec, req)
+
+	body := rec.Body.String()
+	if !strings.Contains(body, "51-65 of 65") {
+		t.Error("last page of the 65 paused tasks expected")
+	}
+	if strings.Count(body, `class="grid task task-paused"`) != 15 {
+		t.Errorf("15 paused tasks expected, got %d", strings.Count(body, `class="grid task task-`))
+	}
+	if !strings.Contains(body, `href="/tasks?status=paused"`) {
+		t.Error("previous page link doesn't keep the filter")
+	}
+}